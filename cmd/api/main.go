@@ -3,26 +3,34 @@ package main
 import (
 	"context"
 	"errors"
-	"math/rand"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"nesta/internal/auth"
 	"nesta/internal/config"
 	"nesta/internal/http/handlers"
 	adminHandlers "nesta/internal/http/handlers/admin"
 	apiHandlers "nesta/internal/http/handlers/api"
 	authHandlers "nesta/internal/http/handlers/auth"
+	courierHandlers "nesta/internal/http/handlers/courier"
 	paymentHandlers "nesta/internal/http/handlers/payments"
 	storeHandlers "nesta/internal/http/handlers/store"
 	subscriptionHandlers "nesta/internal/http/handlers/subscriptions"
 	userHandlers "nesta/internal/http/handlers/users"
+	"nesta/internal/http/middleware"
 	"nesta/internal/http/server"
+	"nesta/internal/payments"
+	"nesta/internal/phone"
+	"nesta/internal/ratelimit"
 	"nesta/internal/repositories"
 	"nesta/internal/services"
+	"nesta/internal/sms"
 	"nesta/internal/storage"
+	"nesta/internal/worker"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -31,8 +39,9 @@ import (
 func main() {
 	cfg := config.Load()
 	logger := setupLogger(cfg.Env)
-	rand.Seed(time.Now().UnixNano())
-
+	if !phone.Supported(cfg.PhoneCountry) {
+		logger.Fatal().Str("country", cfg.PhoneCountry).Msg("unsupported PHONE_DEFAULT_COUNTRY")
+	}
 	store, err := storage.NewPostgres(cfg.DatabaseURL)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("failed to init database")
@@ -50,21 +59,66 @@ func main() {
 	repoComplexRequests := repositories.NewComplexRequestRepository(store.DB)
 	repoPlans := repositories.NewPlanRepository(store.DB)
 	repoSubscriptions := repositories.NewSubscriptionRepository(store.DB)
+	repoSubscriptionEvents := repositories.NewSubscriptionEventRepository(store.DB)
+	repoSubscriptionPauses := repositories.NewSubscriptionPauseRepository(store.DB)
+	repoPlanChanges := repositories.NewPlanChangeRepository(store.DB)
 	repoProducts := repositories.NewProductRepository(store.DB)
 	repoOrders := repositories.NewOrderRepository(store.DB)
 	repoPayments := repositories.NewPaymentRepository(store.DB)
 	repoPickups := repositories.NewPickupLogRepository(store.DB)
+	repoCouriers := repositories.NewCourierRepository(store.DB)
+	repoAuditLogs := repositories.NewAuditLogRepository(store.DB)
+	repoPaymentEvents := repositories.NewPaymentEventRepository(store.DB)
+	repoPaymentRefunds := repositories.NewPaymentRefundRepository(store.DB)
+	repoNotifications := repositories.NewNotificationRepository(store.DB)
+
+	otpSender, err := newOTPSender(cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to init otp sender")
+	}
+	if cfg.Env != "development" && cfg.OTPSender != "http" {
+		logger.Warn().Str("sender", cfg.OTPSender).Msg("otp codes are not delivered by sms")
+	}
+	if cfg.Env != "development" && cfg.OTPPepper == "dev-otp-pepper" {
+		logger.Warn().Msg("OTP_PEPPER is not set")
+	}
+	if cfg.Env != "development" && cfg.RefreshSecret == "dev-refresh-secret" {
+		logger.Warn().Msg("REFRESH_TOKEN_SECRET is not set")
+	}
+
+	keys, err := newKeySet(cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to load jwt keys")
+	}
+	if cfg.Env != "development" && keys.SigningAlgorithm() == auth.AlgHS256 {
+		logger.Warn().Msg("JWT_PRIVATE_KEY_FILE is not set, tokens are signed with JWT_SECRET")
+	}
+
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("invalid TRUSTED_PROXIES")
+	}
+	var limiter ratelimit.Limiter = &ratelimit.PostgresLimiter{DB: store.DB}
+	if cfg.RateLimitBackend == "memory" {
+		limiter = ratelimit.NewMemoryLimiter()
+	}
 
 	authService := &services.AuthService{
+		DB:             store.DB,
+		Sender:         otpSender,
 		Users:          repoUsers,
 		OTP:            repoOTP,
 		RefreshTokens:  repoRefresh,
-		JWTSecret:      cfg.JWTSecret,
+		Keys:           keys,
 		AccessTTL:      cfg.AccessTokenTTL,
 		RefreshTTL:     cfg.RefreshTokenTTL,
+		RefreshGrace:   cfg.RefreshGrace,
+		RefreshSecret:  cfg.RefreshSecret,
 		OTPTTL:         cfg.OTPTTL,
 		OTPRateLimit:   cfg.OTPRateLimit,
 		OTPMaxAttempts: cfg.OTPMaxAttempts,
+		OTPPepper:      cfg.OTPPepper,
+		PhoneCountry:   cfg.PhoneCountry,
 	}
 
 	complexService := &services.ComplexService{
@@ -72,54 +126,131 @@ func main() {
 		Complexes:       repoComplexes,
 		Requests:        repoComplexRequests,
 		ThresholdStatus: "PLANNED",
-	}
-
-	subscriptionService := &services.SubscriptionService{
-		Subscriptions: repoSubscriptions,
-		Complexes:     repoComplexes,
-		Plans:         repoPlans,
+		PhoneCountry:    cfg.PhoneCountry,
 	}
 
 	orderService := &services.OrderService{
+		DB:       store.DB,
 		Orders:   repoOrders,
 		Products: repoProducts,
 	}
 
+	paymentProviders := newPaymentProviders(cfg)
+	if _, err := paymentProviders.Get(cfg.BillingProvider); err != nil {
+		logger.Fatal().Err(err).Strs("configured", paymentProviders.Names()).Msg("invalid BILLING_PROVIDER")
+	}
+
 	paymentService := &services.PaymentService{
 		DB:            store.DB,
 		Payments:      repoPayments,
+		Events:        repoPaymentEvents,
+		Refunds:       repoPaymentRefunds,
 		Orders:        repoOrders,
 		Subscriptions: repoSubscriptions,
+		Providers:     paymentProviders,
+		Provider:      cfg.BillingProvider,
+		Currency:      cfg.PaymentCurrency,
+		Limiter:       limiter,
+		RejectedLimit: ratelimit.Rule(cfg.WebhookRejected),
+	}
+
+	subscriptionService := &services.SubscriptionService{
+		DB:              store.DB,
+		Subscriptions:   repoSubscriptions,
+		Complexes:       repoComplexes,
+		Plans:           repoPlans,
+		Payments:        paymentService,
+		PaymentProvider: cfg.BillingProvider,
+		CancelPolicy:    cfg.SubscriptionPolicy,
+	}
+
+	billingService := &services.BillingService{
+		DB:          store.DB,
+		Providers:   paymentProviders,
+		Provider:    cfg.BillingProvider,
+		Currency:    cfg.PaymentCurrency,
+		RenewBefore: cfg.BillingRenewBefore,
+		GracePeriod: cfg.BillingGracePeriod,
+		BatchSize:   cfg.BillingBatchSize,
+	}
+
+	paymentExpiry := &services.PaymentExpiryService{
+		DB:        store.DB,
+		TTL:       cfg.PaymentExpiryTTL,
+		BatchSize: cfg.BillingBatchSize,
+	}
+
+	pickupScheduler := &services.PickupScheduler{
+		DB:        store.DB,
+		Horizon:   cfg.PickupHorizonDays,
+		BatchSize: cfg.BillingBatchSize,
+	}
+
+	auditService := &services.AuditService{DB: store.DB}
+
+	courierService := &services.CourierService{
+		DB:       store.DB,
+		Couriers: repoCouriers,
 	}
 
 	deps := server.Dependencies{
 		Health: handlers.HealthHandler{DBPinger: store.Ping},
-		Auth:   authHandlers.Handler{Auth: authService},
+		JWKS:   handlers.JWKSHandler{Keys: keys},
+		Auth: authHandlers.Handler{
+			Auth:           authService,
+			Logger:         logger,
+			DevMode:        cfg.Env == "development",
+			Limiter:        limiter,
+			TrustedProxies: trustedProxies,
+			Limits: authHandlers.Limits{
+				SendPhone:    ratelimit.Rule(cfg.OTPSendPhone),
+				SendIP:       ratelimit.Rule(cfg.OTPSendIP),
+				SendDevice:   ratelimit.Rule(cfg.OTPSendDevice),
+				VerifyPhone:  ratelimit.Rule(cfg.OTPVerifyPhone),
+				VerifyIP:     ratelimit.Rule(cfg.OTPVerifyIP),
+				VerifyDevice: ratelimit.Rule(cfg.OTPVerifyDevice),
+			},
+		},
 		Complexes: apiHandlers.ComplexHandler{
 			Complexes: repoComplexes,
 			Requests:  repoComplexRequests,
 			Service:   complexService,
-			JWTSecret: cfg.JWTSecret,
+			Keys:      keys,
+			Sessions:  authService,
 		},
 		Plans:   apiHandlers.PlanHandler{Plans: repoPlans},
 		Pickups: apiHandlers.PickupHandler{Logs: repoPickups},
 		Subscriptions: subscriptionHandlers.Handler{
 			Service:       subscriptionService,
 			Subscriptions: repoSubscriptions,
+			Events:        repoSubscriptionEvents,
+			Pauses:        repoSubscriptionPauses,
+			PlanChanges:   repoPlanChanges,
 		},
 		Users:          userHandlers.Handler{Users: repoUsers},
 		Products:       storeHandlers.ProductHandler{Products: repoProducts},
 		Orders:         storeHandlers.OrderHandler{Service: orderService, Orders: repoOrders},
-		Payments:       paymentHandlers.Handler{Payments: paymentService},
-		AdminComplexes: adminHandlers.ComplexHandler{Complexes: repoComplexes, Service: complexService},
-		AdminPlans:     adminHandlers.PlanHandler{Plans: repoPlans},
+		Payments:       paymentHandlers.Handler{Payments: paymentService, Logger: logger},
+		AdminComplexes: adminHandlers.ComplexHandler{Complexes: repoComplexes, Service: complexService, Audit: auditService},
+		AdminPlans:     adminHandlers.PlanHandler{Plans: repoPlans, Audit: auditService},
 		AdminSubs:      adminHandlers.SubscriptionHandler{Subscriptions: repoSubscriptions, Service: subscriptionService},
-		AdminProducts:  adminHandlers.ProductHandler{Products: repoProducts},
-		AdminOrders:    adminHandlers.OrderHandler{Orders: repoOrders},
-		AdminPickups:   adminHandlers.PickupLogHandler{Logs: repoPickups},
+		AdminProducts:  adminHandlers.ProductHandler{Products: repoProducts, Audit: auditService},
+		AdminOrders:    adminHandlers.OrderHandler{Orders: repoOrders, Audit: auditService},
+		AdminPickups:   adminHandlers.PickupLogHandler{Logs: repoPickups, Service: &services.PickupService{DB: store.DB}, Importer: &services.PickupImporter{DB: store.DB}},
+		AdminCouriers:  adminHandlers.CourierHandler{Couriers: repoCouriers, Service: courierService},
+		AdminSessions:  adminHandlers.SessionHandler{Auth: authService},
+		AdminRoles:     adminHandlers.RoleHandler{Roles: &services.RoleService{DB: store.DB}},
+		AdminAudit:     adminHandlers.AuditLogHandler{Logs: repoAuditLogs},
+		AdminPayEvents: adminHandlers.PaymentEventHandler{Events: repoPaymentEvents, Payments: paymentService},
+		AdminPayments:  adminHandlers.PaymentHandler{Payments: paymentService, Records: repoPayments},
+		MySessions:     userHandlers.SessionHandler{Auth: authService},
+		MyNotices:      userHandlers.NotificationHandler{Notifications: repoNotifications},
+		Sessions:       authService,
+		Courier:        courierHandlers.Handler{Service: courierService},
+		TrustedProxies: trustedProxies,
 	}
 
-	appServer := server.New(logger, deps, cfg.JWTSecret)
+	appServer := server.New(logger, deps, keys)
 
 	httpServer := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -129,6 +260,22 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	runner := &worker.Runner{Logger: logger}
+	if cfg.WorkerEnabled {
+		runner.Jobs = append(runner.Jobs,
+			worker.Job{Name: "billing", Interval: cfg.BillingInterval, Run: billingService.Run},
+			worker.Job{Name: "subscription_cancellations", Interval: cfg.BillingInterval, Run: subscriptionService.FinalizeCancellations},
+			worker.Job{Name: "subscription_pauses", Interval: cfg.BillingInterval, Run: subscriptionService.ApplyPauses},
+			worker.Job{Name: "rate_limit_cleanup", Interval: time.Hour, Run: func(ctx context.Context) error { return limiter.Prune(ctx, 24*time.Hour) }},
+			worker.Job{Name: "pickup_schedule", Interval: cfg.PickupInterval, Run: pickupScheduler.Run},
+			worker.Job{Name: "payment_expiry", Interval: cfg.BillingInterval, Run: paymentExpiry.Run},
+		)
+	}
+	runner.Start(workerCtx)
+
 	go func() {
 		logger.Info().Str("port", cfg.Port).Msg("server started")
 		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -146,6 +293,9 @@ func main() {
 	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		logger.Error().Err(err).Msg("server shutdown error")
 	}
+
+	stopWorkers()
+	runner.Wait()
 }
 
 func setupLogger(env string) zerolog.Logger {
@@ -156,3 +306,62 @@ func setupLogger(env string) zerolog.Logger {
 	}
 	return log.Logger
 }
+
+func newOTPSender(cfg config.Config) (services.OTPSender, error) {
+	switch cfg.OTPSender {
+	case "http":
+		if cfg.SMSGatewayURL == "" {
+			return nil, errors.New("SMS_GATEWAY_URL required for http sender")
+		}
+		return sms.NewHTTPSender(cfg.SMSGatewayURL, cfg.SMSGatewayToken), nil
+	case "console":
+		return sms.NewConsoleSender(), nil
+	case "file":
+		return &sms.FileSender{Path: cfg.OTPSenderFile}, nil
+	case "fake":
+		return &sms.FakeSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown OTP_SENDER %q", cfg.OTPSender)
+	}
+}
+
+// newPaymentProviders registers manual payments plus every provider that has
+// credentials configured. The fake provider is only for development and tests.
+func newPaymentProviders(cfg config.Config) *payments.Registry {
+	providers := []payments.Provider{payments.Manual{}}
+	if cfg.CloudPaymentsKey != "" {
+		providers = append(providers, payments.NewCloudPayments(cfg.CloudPaymentsID, cfg.CloudPaymentsKey, cfg.CloudPaymentsURL))
+	}
+	if cfg.FakePaymentSecret != "" && cfg.Env == "development" {
+		providers = append(providers, &payments.Fake{Secret: cfg.FakePaymentSecret})
+	}
+	return payments.NewRegistry(providers...)
+}
+
+// newKeySet signs with JWT_PRIVATE_KEY_FILE when set and keeps accepting
+// tokens from the keys in JWT_VERIFY_KEY_FILES, e.g. the previous key during
+// a rotation. Without a private key it falls back to HS256 with JWT_SECRET.
+func newKeySet(cfg config.Config) (*auth.KeySet, error) {
+	var verify []*auth.Key
+	for _, path := range cfg.JWTVerifyKeyFiles {
+		key, err := auth.LoadPublicKey(path, "")
+		if err != nil {
+			return nil, err
+		}
+		verify = append(verify, key)
+	}
+
+	if cfg.JWTPrivateKeyFile == "" {
+		signing, err := auth.NewHMACKey(cfg.JWTSecret)
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewKeySet(signing, verify, cfg.JWTIssuer, cfg.JWTAudience)
+	}
+
+	signing, err := auth.LoadPrivateKey(cfg.JWTPrivateKeyFile, cfg.JWTKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewKeySet(signing, verify, cfg.JWTIssuer, cfg.JWTAudience)
+}
@@ -0,0 +1,72 @@
+// Command bootstrap-admin makes the user with the given phone a super_admin,
+// creating the user if needed. It refuses to run once a super_admin exists
+// unless -force is passed; afterwards roles are managed through the admin API.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"os"
+
+	"nesta/internal/auth"
+	"nesta/internal/config"
+	"nesta/internal/phone"
+	"nesta/internal/repositories"
+	"nesta/internal/services"
+	"nesta/internal/storage"
+
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	rawPhone := flag.String("phone", "", "phone of the user to promote")
+	force := flag.Bool("force", false, "promote even if a super_admin already exists")
+	flag.Parse()
+
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout}).With().Timestamp().Logger()
+	cfg := config.Load()
+
+	normalized, err := phone.Normalize(*rawPhone, cfg.PhoneCountry)
+	if err != nil {
+		logger.Fatal().Str("phone", *rawPhone).Msg("-phone must be a valid phone number")
+	}
+
+	store, err := storage.NewPostgres(cfg.DatabaseURL)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to init database")
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	roles := &services.RoleService{DB: store.DB}
+	users := repositories.NewUserRepository(store.DB)
+
+	existing, err := roles.CountSuperAdmins(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to count super admins")
+	}
+	if existing > 0 && !*force {
+		logger.Fatal().Int("super_admins", existing).Msg("a super_admin already exists, use the admin API or -force")
+	}
+
+	user, err := users.FindByPhone(ctx, normalized)
+	if errors.Is(err, sql.ErrNoRows) {
+		id, idErr := services.NewID()
+		if idErr != nil {
+			logger.Fatal().Err(idErr).Msg("failed to generate id")
+		}
+		user = repositories.User{ID: id, Phone: normalized, Role: auth.RoleUser}
+		err = users.Create(ctx, user)
+	}
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to load user")
+	}
+
+	previous, err := roles.SetRole(ctx, "", user.ID, auth.RoleSuperAdmin)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to grant role")
+	}
+	logger.Info().Str("user_id", user.ID).Str("phone", normalized).Str("previous_role", previous).Msg("super_admin granted")
+}
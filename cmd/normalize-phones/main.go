@@ -0,0 +1,290 @@
+// Command normalize-phones rewrites stored phone numbers to E.164 and merges
+// users and complex requests that turn out to be duplicates. It runs in a
+// single transaction; pass -dry-run to only report what would change.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"os"
+	"sort"
+	"time"
+
+	"nesta/internal/config"
+	"nesta/internal/phone"
+	"nesta/internal/storage"
+
+	"github.com/rs/zerolog"
+)
+
+type userRow struct {
+	id        string
+	phone     string
+	role      string
+	createdAt time.Time
+}
+
+type requestRow struct {
+	id        string
+	complexID string
+	phone     string
+	verified  bool
+	createdAt time.Time
+}
+
+type stats struct {
+	usersUpdated    int
+	usersMerged     int
+	requestsUpdated int
+	requestsMerged  int
+	otpDeleted      int
+	invalid         int
+}
+
+// Tables whose user reference is moved to the surviving user on merge.
+var userReferences = []struct {
+	table  string
+	column string
+}{
+	{"subscriptions", "user_id"},
+	{"orders", "user_id"},
+	{"refresh_tokens", "user_id"},
+	{"pickup_logs", "courier_id"},
+	{"subscription_events", "actor_id"},
+	{"pickup_log_history", "actor_id"},
+	{"audit_logs", "admin_id"},
+	{"payment_refunds", "admin_id"},
+	{"notifications", "user_id"},
+}
+
+var rolePriority = map[string]int{"super_admin": 4, "admin": 3, "support": 2, "courier": 1}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report changes without committing")
+	flag.Parse()
+
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout}).With().Timestamp().Logger()
+	cfg := config.Load()
+	if !phone.Supported(cfg.PhoneCountry) {
+		logger.Fatal().Str("country", cfg.PhoneCountry).Msg("unsupported PHONE_DEFAULT_COUNTRY")
+	}
+
+	store, err := storage.NewPostgres(cfg.DatabaseURL)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to init database")
+	}
+	defer store.Close()
+
+	result, err := run(context.Background(), store.DB, cfg.PhoneCountry, *dryRun, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("normalization failed")
+	}
+	logger.Info().
+		Bool("dry_run", *dryRun).
+		Int("users_updated", result.usersUpdated).
+		Int("users_merged", result.usersMerged).
+		Int("requests_updated", result.requestsUpdated).
+		Int("requests_merged", result.requestsMerged).
+		Int("otp_deleted", result.otpDeleted).
+		Int("invalid", result.invalid).
+		Msg("phone normalization finished")
+}
+
+func run(ctx context.Context, db *sql.DB, country string, dryRun bool, logger zerolog.Logger) (stats, error) {
+	var result stats
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := mergeUsers(ctx, tx, country, &result, logger); err != nil {
+		return result, err
+	}
+	if err := mergeRequests(ctx, tx, country, &result, logger); err != nil {
+		return result, err
+	}
+	if err := normalizeOTP(ctx, tx, country, &result); err != nil {
+		return result, err
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+func mergeUsers(ctx context.Context, tx *sql.Tx, country string, result *stats, logger zerolog.Logger) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, phone, role, created_at FROM users ORDER BY created_at, id`)
+	if err != nil {
+		return err
+	}
+	groups := map[string][]userRow{}
+	for rows.Next() {
+		var item userRow
+		if err := rows.Scan(&item.id, &item.phone, &item.role, &item.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		normalized, err := phone.Normalize(item.phone, country)
+		if err != nil {
+			logger.Warn().Str("user_id", item.id).Str("phone", item.phone).Msg("cannot normalize user phone, left as is")
+			result.invalid++
+			continue
+		}
+		groups[normalized] = append(groups[normalized], item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for normalized, users := range groups {
+		// Keep the most privileged account, then the oldest.
+		sort.SliceStable(users, func(i, j int) bool {
+			return rolePriority[users[i].role] > rolePriority[users[j].role]
+		})
+		keeper := users[0]
+
+		for _, duplicate := range users[1:] {
+			for _, ref := range userReferences {
+				if _, err := tx.ExecContext(ctx, `UPDATE `+ref.table+` SET `+ref.column+` = $2 WHERE `+ref.column+` = $1`, duplicate.id, keeper.id); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO courier_complexes (courier_id, complex_id)
+				SELECT $2, complex_id FROM courier_complexes WHERE courier_id = $1
+				ON CONFLICT DO NOTHING
+			`, duplicate.id, keeper.id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `
+				UPDATE users k SET
+					name = COALESCE(k.name, d.name),
+					email = COALESCE(k.email, d.email),
+					default_address_json = COALESCE(k.default_address_json, d.default_address_json)
+				FROM users d
+				WHERE k.id = $2 AND d.id = $1
+			`, duplicate.id, keeper.id)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, duplicate.id); err != nil {
+				return err
+			}
+			logger.Info().Str("phone", normalized).Str("merged", duplicate.id).Str("into", keeper.id).Msg("merged duplicate user")
+			result.usersMerged++
+		}
+
+		if keeper.phone != normalized {
+			if _, err := tx.ExecContext(ctx, `UPDATE users SET phone = $2 WHERE id = $1`, keeper.id, normalized); err != nil {
+				return err
+			}
+			result.usersUpdated++
+		}
+	}
+	return nil
+}
+
+func mergeRequests(ctx context.Context, tx *sql.Tx, country string, result *stats, logger zerolog.Logger) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, complex_id, phone, verified, created_at FROM complex_requests ORDER BY created_at, id`)
+	if err != nil {
+		return err
+	}
+	groups := map[[2]string][]requestRow{}
+	for rows.Next() {
+		var item requestRow
+		if err := rows.Scan(&item.id, &item.complexID, &item.phone, &item.verified, &item.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		normalized, err := phone.Normalize(item.phone, country)
+		if err != nil {
+			logger.Warn().Str("request_id", item.id).Str("phone", item.phone).Msg("cannot normalize request phone, left as is")
+			result.invalid++
+			continue
+		}
+		key := [2]string{item.complexID, normalized}
+		groups[key] = append(groups[key], item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	recount := map[string]bool{}
+	for key, requests := range groups {
+		// Prefer a verified request so the complex keeps its confirmed demand.
+		sort.SliceStable(requests, func(i, j int) bool {
+			return requests[i].verified && !requests[j].verified
+		})
+		keeper := requests[0]
+
+		for _, duplicate := range requests[1:] {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM complex_requests WHERE id = $1`, duplicate.id); err != nil {
+				return err
+			}
+			if duplicate.verified {
+				recount[key[0]] = true
+			}
+			result.requestsMerged++
+		}
+
+		if keeper.phone != key[1] {
+			if _, err := tx.ExecContext(ctx, `UPDATE complex_requests SET phone = $2 WHERE id = $1`, keeper.id, key[1]); err != nil {
+				return err
+			}
+			result.requestsUpdated++
+		}
+	}
+
+	for complexID := range recount {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE residential_complexes
+			SET current_requests = (SELECT COUNT(*) FROM complex_requests WHERE complex_id = $1 AND verified)
+			WHERE id = $1
+		`, complexID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeOTP deletes outstanding codes stored under a non-normalized phone.
+// Their HMAC covers the raw phone, so they could not be verified after a
+// rewrite; the user just requests a new code.
+func normalizeOTP(ctx context.Context, tx *sql.Tx, country string, result *stats) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, phone FROM otp_codes WHERE used_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var id, raw string
+		if err := rows.Scan(&id, &raw); err != nil {
+			rows.Close()
+			return err
+		}
+		normalized, err := phone.Normalize(raw, country)
+		if err != nil || normalized != raw {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range stale {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM otp_codes WHERE id = $1`, id); err != nil {
+			return err
+		}
+		result.otpDeleted++
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Command reconcile-payments compares our payments with the provider: either
+// with a settlement report (-report, CSV or JSON) or by querying the provider
+// API for every payment created in the period. Discrepancies are logged and
+// optionally written as JSON to -output; -fix applies statuses the provider
+// is safely ahead on, exactly as a webhook would.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nesta/internal/config"
+	"nesta/internal/payments"
+	"nesta/internal/repositories"
+	"nesta/internal/services"
+	"nesta/internal/storage"
+
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	provider := flag.String("provider", "", "provider to reconcile (default BILLING_PROVIDER)")
+	reportPath := flag.String("report", "", "settlement report file; without it the provider API is queried")
+	format := flag.String("format", "", "report format: csv or json (default by file extension)")
+	from := flag.String("from", "", "first day of the period, YYYY-MM-DD (default 7 days ago)")
+	to := flag.String("to", "", "last day of the period, YYYY-MM-DD (default today)")
+	fix := flag.Bool("fix", false, "apply safe status fixes")
+	output := flag.String("output", "", "write the discrepancy report as JSON to this file")
+	flag.Parse()
+
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout}).With().Timestamp().Logger()
+	cfg := config.Load()
+	if *provider == "" {
+		*provider = cfg.BillingProvider
+	}
+
+	req := services.ReconcileRequest{Provider: *provider, Fix: *fix}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	var err error
+	if req.From, err = parseDay(*from, today.AddDate(0, 0, -7)); err != nil {
+		logger.Fatal().Err(err).Msg("invalid -from")
+	}
+	if req.To, err = parseDay(*to, today); err != nil {
+		logger.Fatal().Err(err).Msg("invalid -to")
+	}
+	// -to names the last day included.
+	req.To = req.To.AddDate(0, 0, 1)
+
+	if *reportPath != "" {
+		if req.Settlements, err = readReport(*reportPath, *format); err != nil {
+			logger.Fatal().Err(err).Msg("failed to read report")
+		}
+	} else if req.Finder, err = newFinder(cfg, *provider); err != nil {
+		logger.Fatal().Err(err).Msg("cannot query provider")
+	}
+
+	store, err := storage.NewPostgres(cfg.DatabaseURL)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to init database")
+	}
+	defer store.Close()
+
+	service := &services.PaymentService{
+		DB:            store.DB,
+		Payments:      repositories.NewPaymentRepository(store.DB),
+		Orders:        repositories.NewOrderRepository(store.DB),
+		Subscriptions: repositories.NewSubscriptionRepository(store.DB),
+	}
+	report, err := service.Reconcile(context.Background(), req)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("reconciliation failed")
+	}
+
+	for _, item := range report.Discrepancies {
+		logger.Warn().
+			Str("kind", item.Kind).
+			Str("provider_payment_id", item.ProviderPaymentID).
+			Str("payment_id", item.PaymentID).
+			Str("local_status", item.LocalStatus).
+			Str("provider_status", item.ProviderStatus).
+			Int("local_amount_cents", item.LocalAmountCents).
+			Int("provider_amount_cents", item.ProviderAmountCents).
+			Bool("fixed", item.Fixed).
+			Str("error", item.Error).
+			Msg("payment discrepancy")
+	}
+	if *output != "" {
+		raw, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*output, raw, 0o644)
+		}
+		if err != nil {
+			logger.Fatal().Err(err).Msg("failed to write report")
+		}
+	}
+	logger.Info().
+		Str("provider", report.Provider).
+		Bool("fix", *fix).
+		Int("checked", report.Checked).
+		Int("matched", report.Matched).
+		Int("skipped", report.Skipped).
+		Int("discrepancies", len(report.Discrepancies)).
+		Int("fixed", report.Fixed).
+		Msg("payment reconciliation finished")
+}
+
+func parseDay(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func readReport(path, format string) ([]payments.Settlement, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return payments.ParseReport(file, format)
+}
+
+// newFinder returns the adapter to query. Only providers with an API that can
+// look payments up are supported; for the rest pass a report.
+func newFinder(cfg config.Config, provider string) (payments.Finder, error) {
+	switch provider {
+	case "cloudpayments":
+		if cfg.CloudPaymentsKey == "" {
+			return nil, errors.New("CLOUDPAYMENTS_API_SECRET is not set")
+		}
+		return payments.NewCloudPayments(cfg.CloudPaymentsID, cfg.CloudPaymentsKey, cfg.CloudPaymentsURL), nil
+	}
+	return nil, fmt.Errorf("%w: %s cannot be queried, pass -report", payments.ErrNotSupported, provider)
+}
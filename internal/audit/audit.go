@@ -0,0 +1,21 @@
+// Package audit carries the acting admin through the request context so that
+// services can record admin mutations without every call passing it along.
+package audit
+
+import "context"
+
+type Actor struct {
+	AdminID string
+	IP      string
+}
+
+type contextKey struct{}
+
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, contextKey{}, actor)
+}
+
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(contextKey{}).(Actor)
+	return actor, ok && actor.AdminID != ""
+}
@@ -1,7 +1,11 @@
 package auth
 
 import (
+	"crypto"
+	"crypto/ed25519"
 	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
@@ -11,17 +15,68 @@ import (
 	"time"
 )
 
+// Tolerated clock difference between us and other services for exp and nbf.
+const clockSkew = 30 * time.Second
+
 type Claims struct {
-	Subject string `json:"sub"`
-	Role    string `json:"role"`
-	Issued  int64  `json:"iat"`
-	Expires int64  `json:"exp"`
-	ID      string `json:"jti"`
+	Subject   string   `json:"sub"`
+	Role      string   `json:"role"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	Issued    int64    `json:"iat"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Expires   int64    `json:"exp"`
+	ID        string   `json:"jti"`
+	Session   string   `json:"sid,omitempty"`
+}
+
+// Audience accepts both forms RFC 7519 allows: a single string or an array.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
 }
 
-func NewToken(secret string, claims Claims) (string, error) {
-	header := map[string]string{"alg": "HS256", "typ": "JWT"}
-	headerJSON, err := json.Marshal(header)
+func (a Audience) contains(value string) bool {
+	for _, item := range a {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// NewToken signs claims with the current key, filling in iss and aud.
+func (s *KeySet) NewToken(claims Claims) (string, error) {
+	claims.Issuer = s.Issuer
+	if s.Audience != "" {
+		claims.Audience = Audience{s.Audience}
+	}
+
+	headerJSON, err := json.Marshal(header{Algorithm: s.signing.Algorithm, Type: "JWT", KeyID: s.signing.ID})
 	if err != nil {
 		return "", err
 	}
@@ -34,20 +89,47 @@ func NewToken(secret string, claims Claims) (string, error) {
 	encodedClaims := base64.RawURLEncoding.EncodeToString(claimsJSON)
 	signingInput := fmt.Sprintf("%s.%s", encodedHeader, encodedClaims)
 
-	signature := sign(secret, signingInput)
+	signature, err := s.signing.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
 
-	return fmt.Sprintf("%s.%s", signingInput, signature), nil
+	return fmt.Sprintf("%s.%s", signingInput, base64.RawURLEncoding.EncodeToString(signature)), nil
 }
 
-func ParseToken(secret, token string) (Claims, error) {
+// ParseToken verifies the signature with the key named by kid, requiring the
+// header alg to match that key, then checks exp, nbf, iss and aud.
+func (s *KeySet) ParseToken(token string) (Claims, error) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
 		return Claims{}, errors.New("invalid token")
 	}
 
-	signingInput := fmt.Sprintf("%s.%s", parts[0], parts[1])
-	expected := sign(secret, signingInput)
-	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Claims{}, errors.New("invalid header")
+	}
+	var head header
+	if err := json.Unmarshal(headerJSON, &head); err != nil {
+		return Claims{}, errors.New("invalid header")
+	}
+
+	key := s.signing
+	if head.KeyID != "" {
+		var ok bool
+		if key, ok = s.keys[head.KeyID]; !ok {
+			return Claims{}, errors.New("unknown key")
+		}
+	}
+	if head.Algorithm != key.Algorithm {
+		return Claims{}, errors.New("unexpected algorithm")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, errors.New("invalid signature")
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
 		return Claims{}, errors.New("invalid signature")
 	}
 
@@ -61,16 +143,54 @@ func ParseToken(secret, token string) (Claims, error) {
 		return Claims{}, errors.New("invalid claims")
 	}
 
-	if claims.Expires > 0 && time.Now().Unix() > claims.Expires {
+	now := time.Now()
+	if claims.Expires == 0 || now.Add(-clockSkew).Unix() > claims.Expires {
 		return Claims{}, errors.New("token expired")
 	}
+	if claims.NotBefore > 0 && now.Add(clockSkew).Unix() < claims.NotBefore {
+		return Claims{}, errors.New("token not yet valid")
+	}
+	if s.Issuer != "" && claims.Issuer != s.Issuer {
+		return Claims{}, errors.New("invalid issuer")
+	}
+	if s.Audience != "" && !claims.Audience.contains(s.Audience) {
+		return Claims{}, errors.New("invalid audience")
+	}
 
 	return claims, nil
 }
 
-func sign(secret, input string) string {
-	mac := hmac.New(sha256.New, []byte(secret))
-	_, _ = mac.Write([]byte(input))
-	signature := mac.Sum(nil)
-	return base64.RawURLEncoding.EncodeToString(signature)
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		_, _ = mac.Write(input)
+		return mac.Sum(nil), nil
+	case AlgRS256:
+		digest := sha256.Sum256(input)
+		return k.Private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case AlgEdDSA:
+		return k.Private.Sign(rand.Reader, input, crypto.Hash(0))
+	}
+	return nil, errors.New("unsupported algorithm")
+}
+
+func (k *Key) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		_, _ = mac.Write(input)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case AlgRS256:
+		pub, ok := k.Public.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case AlgEdDSA:
+		pub, ok := k.Public.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, input, signature)
+	}
+	return false
 }
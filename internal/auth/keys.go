@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is one signing or verification key. Private is nil for keys that are
+// only kept to verify tokens issued before a rotation.
+type Key struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+	Private   crypto.Signer
+	secret    []byte
+}
+
+// KeySet signs tokens with its current key and verifies them with any key it
+// holds, selected by the kid header.
+type KeySet struct {
+	Issuer   string
+	Audience string
+
+	signing *Key
+	keys    map[string]*Key
+}
+
+func NewKeySet(signing *Key, verify []*Key, issuer, audience string) (*KeySet, error) {
+	if signing == nil {
+		return nil, errors.New("signing key required")
+	}
+	set := &KeySet{Issuer: issuer, Audience: audience, signing: signing, keys: map[string]*Key{signing.ID: signing}}
+	for _, key := range verify {
+		if key.Algorithm == AlgHS256 {
+			return nil, errors.New("verification keys must be asymmetric")
+		}
+		if existing, ok := set.keys[key.ID]; ok && existing != key {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		set.keys[key.ID] = key
+	}
+	return set, nil
+}
+
+// NewHMACKey keeps the legacy shared-secret setup working. Such a key is
+// never published in the JWKS.
+func NewHMACKey(secret string) (*Key, error) {
+	if secret == "" {
+		return nil, errors.New("jwt secret required")
+	}
+	return &Key{ID: "hs256", Algorithm: AlgHS256, secret: []byte(secret)}, nil
+}
+
+// LoadPrivateKey reads a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519
+// (PKCS#8) private key. An empty id is replaced by the key thumbprint.
+func LoadPrivateKey(path, id string) (*Key, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported private key", path)
+	}
+	key, err := newKey(signer.Public(), id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key.Private = signer
+	return key, nil
+}
+
+// LoadPublicKey reads a PEM encoded public key or certificate, or a private
+// key of which only the public half is kept.
+func LoadPublicKey(path, id string) (*Key, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var public crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			public = cert.PublicKey
+		}
+	case "RSA PRIVATE KEY", "PRIVATE KEY":
+		private, loadErr := LoadPrivateKey(path, id)
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		private.Private = nil
+		return private, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key, err := newKey(public, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+func newKey(public crypto.PublicKey, id string) (*Key, error) {
+	key := &Key{ID: id, Public: public}
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("rsa key must be at least 2048 bits")
+		}
+		key.Algorithm = AlgRS256
+	case ed25519.PublicKey:
+		key.Algorithm = AlgEdDSA
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+	if key.ID == "" {
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(der)
+		key.ID = base64.RawURLEncoding.EncodeToString(sum[:])[:16]
+	}
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS returns the public keys other services need to verify our tokens.
+func (s *KeySet) JWKS() []JWK {
+	keys := make([]JWK, 0, len(s.keys))
+	for _, key := range s.keys {
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KeyID < keys[j].KeyID })
+	return keys
+}
+
+func (s *KeySet) SigningAlgorithm() string {
+	return s.signing.Algorithm
+}
@@ -0,0 +1,104 @@
+package auth
+
+import "sort"
+
+const (
+	RoleUser       = "user"
+	RoleCourier    = "courier"
+	RoleSupport    = "support"
+	RoleAdmin      = "admin"
+	RoleSuperAdmin = "super_admin"
+)
+
+type Permission string
+
+const (
+	PermComplexesRead      Permission = "complexes:read"
+	PermComplexesWrite     Permission = "complexes:write"
+	PermPlansRead          Permission = "plans:read"
+	PermPlansWrite         Permission = "plans:write"
+	PermSubscriptionsRead  Permission = "subscriptions:read"
+	PermSubscriptionsWrite Permission = "subscriptions:write"
+	PermProductsRead       Permission = "products:read"
+	PermProductsWrite      Permission = "products:write"
+	PermOrdersRead         Permission = "orders:read"
+	PermOrdersWrite        Permission = "orders:write"
+	PermOrdersRefund       Permission = "orders:refund"
+	PermPaymentsRead       Permission = "payments:read"
+	PermPaymentsWrite      Permission = "payments:write"
+	PermPickupsRead        Permission = "pickups:read"
+	PermPickupsWrite       Permission = "pickups:write"
+	PermPickupsMark        Permission = "pickups:mark"
+	PermCouriersRead       Permission = "couriers:read"
+	PermCouriersWrite      Permission = "couriers:write"
+	PermSessionsRead       Permission = "sessions:read"
+	PermSessionsWrite      Permission = "sessions:write"
+	PermRolesWrite         Permission = "roles:write"
+	PermAuditRead          Permission = "audit:read"
+)
+
+var supportPermissions = []Permission{
+	PermComplexesRead,
+	PermPlansRead,
+	PermSubscriptionsRead,
+	PermProductsRead,
+	PermOrdersRead,
+	PermPaymentsRead,
+	PermPickupsRead,
+	PermCouriersRead,
+	PermSessionsRead,
+	PermSessionsWrite,
+}
+
+var adminPermissions = append([]Permission{
+	PermComplexesWrite,
+	PermPlansWrite,
+	PermSubscriptionsWrite,
+	PermProductsWrite,
+	PermOrdersWrite,
+	PermOrdersRefund,
+	PermPaymentsWrite,
+	PermPickupsWrite,
+	PermCouriersWrite,
+	PermAuditRead,
+}, supportPermissions...)
+
+// rolePermissions is the single source of truth for what each role may do.
+// Couriers only act on their own route; admins manage everything except roles.
+var rolePermissions = map[string][]Permission{
+	RoleUser:       nil,
+	RoleCourier:    {PermPickupsMark},
+	RoleSupport:    supportPermissions,
+	RoleAdmin:      adminPermissions,
+	RoleSuperAdmin: append([]Permission{PermRolesWrite}, adminPermissions...),
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role string, permission Permission) bool {
+	for _, item := range rolePermissions[role] {
+		if item == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles returns every role with its permissions, sorted for display.
+func Roles() map[string][]Permission {
+	roles := make(map[string][]Permission, len(rolePermissions))
+	for role, permissions := range rolePermissions {
+		sorted := append([]Permission{}, permissions...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		roles[role] = sorted
+	}
+	return roles
+}
+
+// IsStaff reports whether the role grants access to the admin API.
+func IsStaff(role string) bool {
+	return role == RoleSupport || role == RoleAdmin || role == RoleSuperAdmin
+}
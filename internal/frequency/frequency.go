@@ -0,0 +1,94 @@
+package frequency
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind string
+
+const (
+	Weekly    Kind = "weekly"
+	Monthly   Kind = "monthly"
+	Quarterly Kind = "quarterly"
+	Yearly    Kind = "yearly"
+	Days      Kind = "days"
+)
+
+var ErrInvalid = errors.New("invalid frequency")
+
+type Frequency struct {
+	Kind Kind
+	Days int
+}
+
+var aliases = map[string]Kind{
+	"weekly":        Weekly,
+	"week":          Weekly,
+	"еженедельно":   Weekly,
+	"monthly":       Monthly,
+	"month":         Monthly,
+	"ежемесячно":    Monthly,
+	"quarterly":     Quarterly,
+	"quarter":       Quarterly,
+	"ежеквартально": Quarterly,
+	"yearly":        Yearly,
+	"year":          Yearly,
+	"annual":        Yearly,
+	"annually":      Yearly,
+	"ежегодно":      Yearly,
+}
+
+// Parse accepts the canonical forms ("weekly", "monthly", "quarterly",
+// "yearly", "days:N") and a few legacy aliases.
+func Parse(value string) (Frequency, error) {
+	normalized := strings.ToLower(strings.TrimSpace(value))
+	if kind, ok := aliases[normalized]; ok {
+		return Frequency{Kind: kind}, nil
+	}
+
+	if rest, ok := strings.CutPrefix(normalized, string(Days)+":"); ok {
+		days, err := strconv.Atoi(rest)
+		if err != nil || days <= 0 {
+			return Frequency{}, ErrInvalid
+		}
+		return Frequency{Kind: Days, Days: days}, nil
+	}
+
+	return Frequency{}, ErrInvalid
+}
+
+func (f Frequency) String() string {
+	if f.Kind == Days {
+		return string(Days) + ":" + strconv.Itoa(f.Days)
+	}
+	return string(f.Kind)
+}
+
+// Next returns the end of a period starting at start. Month based kinds use
+// calendar months, clamping to the last day when the target month is shorter.
+func (f Frequency) Next(start time.Time) time.Time {
+	switch f.Kind {
+	case Weekly:
+		return start.AddDate(0, 0, 7)
+	case Quarterly:
+		return addMonths(start, 3)
+	case Yearly:
+		return addMonths(start, 12)
+	case Days:
+		return start.AddDate(0, 0, f.Days)
+	default:
+		return addMonths(start, 1)
+	}
+}
+
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
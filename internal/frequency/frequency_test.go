@@ -0,0 +1,68 @@
+package frequency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Frequency
+		wantErr bool
+	}{
+		{value: "weekly", want: Frequency{Kind: Weekly}},
+		{value: " Monthly ", want: Frequency{Kind: Monthly}},
+		{value: "ежемесячно", want: Frequency{Kind: Monthly}},
+		{value: "quarter", want: Frequency{Kind: Quarterly}},
+		{value: "annual", want: Frequency{Kind: Yearly}},
+		{value: "days:10", want: Frequency{Kind: Days, Days: 10}},
+		{value: "days:0", wantErr: true},
+		{value: "days:-3", wantErr: true},
+		{value: "days:x", wantErr: true},
+		{value: "daily", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.value)
+		if tt.wantErr {
+			if err != ErrInvalid {
+				t.Errorf("Parse(%q) = %v, %v; want ErrInvalid", tt.value, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+		if back, err := Parse(got.String()); err != nil || back != got {
+			t.Errorf("Parse(%q) does not round-trip: %v, %v", got.String(), back, err)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name  string
+		freq  Frequency
+		start time.Time
+		want  time.Time
+	}{
+		{name: "weekly", freq: Frequency{Kind: Weekly}, start: at(2026, time.December, 29), want: at(2027, time.January, 5)},
+		{name: "days", freq: Frequency{Kind: Days, Days: 10}, start: at(2026, time.February, 25), want: at(2026, time.March, 7)},
+		{name: "monthly", freq: Frequency{Kind: Monthly}, start: at(2026, time.January, 15), want: at(2026, time.February, 15)},
+		{name: "monthly clamps to month end", freq: Frequency{Kind: Monthly}, start: at(2026, time.January, 31), want: at(2026, time.February, 28)},
+		{name: "monthly leap year", freq: Frequency{Kind: Monthly}, start: at(2024, time.January, 31), want: at(2024, time.February, 29)},
+		{name: "monthly 31 to 30", freq: Frequency{Kind: Monthly}, start: at(2026, time.March, 31), want: at(2026, time.April, 30)},
+		{name: "monthly over year end", freq: Frequency{Kind: Monthly}, start: at(2026, time.December, 31), want: at(2027, time.January, 31)},
+		{name: "quarterly clamps", freq: Frequency{Kind: Quarterly}, start: at(2026, time.November, 30), want: at(2027, time.February, 28)},
+		{name: "yearly from leap day", freq: Frequency{Kind: Yearly}, start: at(2024, time.February, 29), want: at(2025, time.February, 28)},
+	}
+	for _, tt := range tests {
+		if got := tt.freq.Next(tt.start); !got.Equal(tt.want) {
+			t.Errorf("%s: Next(%v) = %v, want %v", tt.name, tt.start, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nesta/internal/http/middleware"
+	"nesta/internal/http/response"
+	"nesta/internal/repositories"
+)
+
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 200
+)
+
+type AuditLogHandler struct {
+	Logs *repositories.AuditLogRepository
+}
+
+// List serves GET /api/v1/admin/audit-logs. Pages are newest first; pass the
+// returned next_cursor as cursor to get the following page.
+func (h AuditLogHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := repositories.AuditLogFilter{
+		AdminID:  query.Get("admin_id"),
+		Entity:   query.Get("entity"),
+		EntityID: query.Get("entity_id"),
+		Limit:    auditDefaultLimit,
+	}
+	fields := map[string]string{}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > auditMaxLimit {
+			fields["limit"] = "expected 1.." + strconv.Itoa(auditMaxLimit)
+		} else {
+			filter.Limit = limit
+		}
+	}
+	if value := query.Get("from"); value != "" {
+		from, _, err := parseAuditTime(value)
+		if err != nil {
+			fields["from"] = "expected RFC 3339 time or YYYY-MM-DD"
+		}
+		filter.From = from
+	}
+	if value := query.Get("to"); value != "" {
+		to, dateOnly, err := parseAuditTime(value)
+		if err != nil {
+			fields["to"] = "expected RFC 3339 time or YYYY-MM-DD"
+		}
+		// A bare date includes the whole day.
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = to
+	}
+	if value := query.Get("cursor"); value != "" {
+		at, id, err := decodeAuditCursor(value)
+		if err != nil {
+			fields["cursor"] = "invalid cursor"
+		}
+		filter.AfterTime, filter.AfterID = at, id
+	}
+	if len(fields) > 0 {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid query", Fields: fields, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	// One extra row tells whether there is a next page.
+	limit := filter.Limit
+	filter.Limit++
+	logs, err := h.Logs.List(r.Context(), filter)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	var nextCursor any
+	if len(logs) > limit {
+		logs = logs[:limit]
+		last := logs[limit-1]
+		nextCursor = encodeAuditCursor(last.CreatedAt, last.ID)
+	}
+
+	items := make([]map[string]any, 0, len(logs))
+	for _, item := range logs {
+		var ip any
+		if item.IP.Valid {
+			ip = item.IP.String
+		}
+		items = append(items, map[string]any{
+			"id":         item.ID,
+			"admin_id":   item.AdminID,
+			"entity":     item.Entity,
+			"entity_id":  item.EntityID,
+			"action":     item.Action,
+			"before":     rawJSON(item.Before),
+			"after":      rawJSON(item.After),
+			"ip":         ip,
+			"created_at": item.CreatedAt,
+		})
+	}
+	response.JSON(w, http.StatusOK, map[string]any{"items": items, "next_cursor": nextCursor})
+}
+
+func parseAuditTime(value string) (time.Time, bool, error) {
+	if parsed, err := time.Parse("2006-01-02", value); err == nil {
+		return parsed, true, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	return parsed, false, err
+}
+
+func encodeAuditCursor(at time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(at.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeAuditCursor(value string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return time.Time{}, "", errors.New("invalid cursor")
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, at)
+	return parsed, id, err
+}
+
+func rawJSON(value []byte) any {
+	if value == nil {
+		return nil
+	}
+	return json.RawMessage(value)
+}
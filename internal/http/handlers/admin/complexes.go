@@ -1,6 +1,8 @@
 package admin
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -14,19 +16,25 @@ import (
 type ComplexHandler struct {
 	Complexes *repositories.ComplexRepository
 	Service   *services.ComplexService
+	Audit     *services.AuditService
 }
 
 type complexCreateRequest struct {
-	Name      string `json:"name"`
-	City      string `json:"city"`
-	Status    string `json:"status"`
-	Threshold int    `json:"threshold_n"`
+	Name        string   `json:"name"`
+	City        string   `json:"city"`
+	Status      string   `json:"status"`
+	Threshold   int      `json:"threshold_n"`
+	ServiceDays []string `json:"service_days"`
 }
 
 type statusUpdateRequest struct {
 	Status string `json:"status"`
 }
 
+type serviceDaysRequest struct {
+	ServiceDays []string `json:"service_days"`
+}
+
 func (h ComplexHandler) List(w http.ResponseWriter, r *http.Request) {
 	items, err := h.Complexes.List(r.Context(), "", "", "", false, 100, 0)
 	if err != nil {
@@ -60,6 +68,15 @@ func (h ComplexHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	serviceDays := services.AllServiceDays
+	if len(req.ServiceDays) > 0 {
+		serviceDays, err = services.ParseServiceDays(req.ServiceDays)
+		if err != nil {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), Fields: map[string]string{"service_days": "expected mon, tue, wed, thu, fri, sat or sun"}, RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+	}
+
 	complex := repositories.ResidentialComplex{
 		ID:              id,
 		Name:            req.Name,
@@ -67,9 +84,13 @@ func (h ComplexHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Status:          req.Status,
 		Threshold:       req.Threshold,
 		CurrentRequests: 0,
+		ServiceDays:     serviceDays,
 	}
 
-	if err := h.Complexes.Create(r.Context(), complex); err != nil {
+	err = h.Audit.Do(r.Context(), "complex", id, services.AuditCreate, func(tx *sql.Tx) error {
+		return h.Complexes.WithTx(tx).Create(r.Context(), complex)
+	})
+	if err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
@@ -77,6 +98,18 @@ func (h ComplexHandler) Create(w http.ResponseWriter, r *http.Request) {
 	response.JSON(w, http.StatusCreated, complex)
 }
 
+func (h ComplexHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/service-days") {
+		h.UpdateServiceDays(w, r)
+		return
+	}
+	h.UpdateStatus(w, r)
+}
+
 func (h ComplexHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/complexes/"), "/status")
 	if id == "" {
@@ -96,10 +129,47 @@ func (h ComplexHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.Complexes.UpdateStatusAndRequests(r.Context(), id, req.Status, complex.CurrentRequests); err != nil {
+	err = h.Audit.Do(r.Context(), "complex", id, services.AuditUpdate, func(tx *sql.Tx) error {
+		return h.Complexes.WithTx(tx).UpdateStatusAndRequests(r.Context(), id, req.Status, complex.CurrentRequests)
+	})
+	if err != nil {
 		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to update", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
 
 	response.JSON(w, http.StatusOK, map[string]string{"status": req.Status})
 }
+
+func (h ComplexHandler) UpdateServiceDays(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/complexes/"), "/service-days")
+	if id == "" {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "complex not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	var req serviceDaysRequest
+	if err := handlers.DecodeJSON(r, &req); err != nil {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid payload", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	serviceDays, err := services.ParseServiceDays(req.ServiceDays)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), Fields: map[string]string{"service_days": "expected mon, tue, wed, thu, fri, sat or sun"}, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	err = h.Audit.Do(r.Context(), "complex", id, services.AuditUpdate, func(tx *sql.Tx) error {
+		return h.Complexes.WithTx(tx).UpdateServiceDays(r.Context(), id, serviceDays)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "complex not found", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to update", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]any{"service_days": services.ServiceDayNames(serviceDays)})
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"nesta/internal/http/handlers"
+	"nesta/internal/http/middleware"
+	"nesta/internal/http/response"
+	"nesta/internal/repositories"
+	"nesta/internal/services"
+)
+
+type CourierHandler struct {
+	Couriers *repositories.CourierRepository
+	Service  *services.CourierService
+}
+
+type courierComplexesRequest struct {
+	ComplexIDs []string `json:"complex_ids"`
+}
+
+func (h CourierHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/couriers/"), "/complexes")
+	if id == "" || !strings.HasSuffix(r.URL.Path, "/complexes") {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "courier not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ids, err := h.Couriers.ListComplexIDs(r.Context(), id)
+		if err != nil {
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		response.JSON(w, http.StatusOK, map[string]any{"complex_ids": ids})
+	case http.MethodPut:
+		var req courierComplexesRequest
+		if err := handlers.DecodeJSON(r, &req); err != nil {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid payload", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		if err := h.Service.AssignComplexes(r.Context(), id, req.ComplexIDs); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "user not found", RequestID: middleware.GetRequestID(r.Context())})
+				return
+			}
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		response.JSON(w, http.StatusOK, map[string]any{"role": "courier", "complex_ids": req.ComplexIDs})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
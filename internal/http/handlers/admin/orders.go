@@ -1,6 +1,7 @@
 package admin
 
 import (
+	"database/sql"
 	"net/http"
 	"strings"
 
@@ -8,10 +9,12 @@ import (
 	"nesta/internal/http/middleware"
 	"nesta/internal/http/response"
 	"nesta/internal/repositories"
+	"nesta/internal/services"
 )
 
 type OrderHandler struct {
 	Orders *repositories.OrderRepository
+	Audit  *services.AuditService
 }
 
 type orderStatusRequest struct {
@@ -48,7 +51,10 @@ func (h OrderHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.Orders.UpdateStatus(r.Context(), id, req.Status); err != nil {
+	err := h.Audit.Do(r.Context(), "order", id, services.AuditUpdate, func(tx *sql.Tx) error {
+		return h.Orders.WithTx(tx).UpdateStatus(r.Context(), id, req.Status)
+	})
+	if err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
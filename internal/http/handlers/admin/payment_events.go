@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"nesta/internal/http/middleware"
+	"nesta/internal/http/response"
+	"nesta/internal/repositories"
+	"nesta/internal/services"
+)
+
+type PaymentEventHandler struct {
+	Events   *repositories.PaymentEventRepository
+	Payments *services.PaymentService
+}
+
+func (h PaymentEventHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	filter := repositories.PaymentEventFilter{
+		Provider:  query.Get("provider"),
+		PaymentID: query.Get("payment_id"),
+		Outcome:   query.Get("outcome"),
+		Limit:     50,
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 200 {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid query", Fields: map[string]string{"limit": "expected 1..200"}, RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	events, err := h.Events.List(r.Context(), filter)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	items := make([]map[string]any, 0, len(events))
+	for _, event := range events {
+		items = append(items, paymentEventJSON(event))
+	}
+	response.JSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// HandleItem serves GET /api/v1/admin/payment-events/{id} and
+// POST /api/v1/admin/payment-events/{id}/reprocess.
+func (h PaymentEventHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/payment-events/")
+	id, action, _ := strings.Cut(path, "/")
+	if id == "" || (action != "" && action != "reprocess") {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		event, err := h.Events.Get(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "event not found", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		if err != nil {
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to load", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		response.JSON(w, http.StatusOK, paymentEventJSON(event))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	event, err := h.Payments.ReprocessEvent(r.Context(), id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && event.ID == "":
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "event not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case errors.Is(err, services.ErrEventNotReprocessable):
+		response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "CONFLICT", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case err != nil && event.ID == "":
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to reprocess", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	// A processing error is recorded on the event and shown in its outcome.
+	response.JSON(w, http.StatusOK, paymentEventJSON(event))
+}
+
+func paymentEventJSON(event repositories.PaymentEvent) map[string]any {
+	return map[string]any{
+		"id":              event.ID,
+		"provider":        event.Provider,
+		"event_id":        nullableString(event.EventID),
+		"payment_id":      nullableString(event.PaymentID),
+		"headers":         rawJSON(event.HeadersRaw),
+		"body":            string(event.Body),
+		"signature_valid": event.SignatureValid,
+		"status":          nullableString(event.Status),
+		"outcome":         event.Outcome,
+		"error":           nullableString(event.Error),
+		"duplicates":      event.Duplicates,
+		"received_at":     event.ReceivedAt,
+		"processed_at":    nullableTime(event.ProcessedAt),
+	}
+}
+
+func nullableString(value sql.NullString) any {
+	if !value.Valid {
+		return nil
+	}
+	return value.String
+}
+
+func nullableTime(value sql.NullTime) any {
+	if !value.Valid {
+		return nil
+	}
+	return value.Time
+}
@@ -0,0 +1,168 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"nesta/internal/http/handlers"
+	"nesta/internal/http/middleware"
+	"nesta/internal/http/response"
+	"nesta/internal/payments"
+	"nesta/internal/repositories"
+	"nesta/internal/services"
+)
+
+type PaymentHandler struct {
+	Payments *services.PaymentService
+	Records  *repositories.PaymentRepository
+}
+
+type refundRequest struct {
+	AmountCents        int                   `json:"amount_cents"`
+	Items              []services.RefundItem `json:"items"`
+	CancelSubscription bool                  `json:"cancel_subscription"`
+	Reason             string                `json:"reason"`
+}
+
+// RefundRequired serves GET /api/v1/admin/payments/refund-required: paid
+// payments that arrived too late to be applied and must be refunded.
+func (h PaymentHandler) RefundRequired(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	list, err := h.Records.ListRefundRequired(r.Context(), 200)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	items := make([]map[string]any, 0, len(list))
+	for _, payment := range list {
+		items = append(items, map[string]any{
+			"id":              payment.ID,
+			"type":            payment.Type,
+			"entity_id":       payment.EntityID,
+			"provider":        payment.Provider,
+			"status":          payment.Status,
+			"amount_cents":    payment.AmountCents,
+			"refunded_cents":  payment.RefundedCents,
+			"refund_required": payment.RefundRequired.String,
+			"created_at":      payment.CreatedAt,
+		})
+	}
+	response.JSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// Confirm serves POST /api/v1/admin/payments/{id}/confirm: an admin records
+// that a manual payment was received.
+func (h PaymentHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/payments/")
+	id, action, _ := strings.Cut(path, "/")
+	if id == "" || action != "confirm" {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	adminID, _ := middleware.UserIDFromContext(r.Context())
+	payment, err := h.Payments.ConfirmManual(r.Context(), id, adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "payment not found", RequestID: middleware.GetRequestID(r.Context())})
+		case errors.Is(err, services.ErrPaymentNotManual):
+			response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "CONFLICT", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		default:
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to confirm", RequestID: middleware.GetRequestID(r.Context())})
+		}
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]any{
+		"id":              payment.ID,
+		"type":            payment.Type,
+		"entity_id":       payment.EntityID,
+		"provider":        payment.Provider,
+		"status":          payment.Status,
+		"amount_cents":    payment.AmountCents,
+		"refund_required": nullableString(payment.RefundRequired),
+		"created_at":      payment.CreatedAt,
+	})
+}
+
+// HandleItem serves POST /api/v1/admin/payments/{id}/refund.
+func (h PaymentHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/payments/")
+	id, action, _ := strings.Cut(path, "/")
+	if id == "" || action != "refund" {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req refundRequest
+	if err := handlers.DecodeJSON(r, &req); err != nil {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid payload", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	if req.AmountCents < 0 {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid payload", Fields: map[string]string{"amount_cents": "must be positive"}, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	adminID, _ := middleware.UserIDFromContext(r.Context())
+	result, err := h.Payments.Refund(r.Context(), services.PaymentRefundRequest{
+		PaymentID:          id,
+		AmountCents:        req.AmountCents,
+		Items:              req.Items,
+		CancelSubscription: req.CancelSubscription,
+		Reason:             strings.TrimSpace(req.Reason),
+		AdminID:            adminID,
+	})
+	if err != nil {
+		var transitionErr *services.TransitionError
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "payment not found", RequestID: middleware.GetRequestID(r.Context())})
+		case errors.Is(err, services.ErrRefundNotAllowed), errors.Is(err, services.ErrRefundInProgress), errors.As(err, &transitionErr):
+			response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "CONFLICT", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		case errors.Is(err, services.ErrRefundAmount):
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), Fields: map[string]string{"amount_cents": err.Error()}, RequestID: middleware.GetRequestID(r.Context())})
+		case errors.Is(err, services.ErrRefundInvalid):
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		case errors.Is(err, services.ErrRefundProvider), errors.Is(err, payments.ErrUnknownProvider):
+			response.ErrorJSON(w, http.StatusBadGateway, response.Error{Code: "PROVIDER_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		default:
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to refund", RequestID: middleware.GetRequestID(r.Context())})
+		}
+		return
+	}
+
+	refund := result.Refund
+	var entityStatus any
+	if result.EntityStatus != "" {
+		entityStatus = result.EntityStatus
+	}
+	response.JSON(w, http.StatusCreated, map[string]any{
+		"refund": map[string]any{
+			"id":                 refund.ID,
+			"payment_id":         refund.PaymentID,
+			"amount_cents":       refund.AmountCents,
+			"reason":             nullableString(refund.Reason),
+			"items":              rawJSON(refund.ItemsRaw),
+			"provider_refund_id": nullableString(refund.ProviderRefundID),
+			"status":             refund.Status,
+			"created_at":         refund.CreatedAt,
+		},
+		"payment_status": result.PaymentStatus,
+		"entity_status":  entityStatus,
+	})
+}
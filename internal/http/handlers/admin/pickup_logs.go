@@ -2,6 +2,8 @@ package admin
 
 import (
 	"database/sql"
+	"errors"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -14,15 +16,20 @@ import (
 )
 
 type PickupLogHandler struct {
-	Logs *repositories.PickupLogRepository
+	Logs     *repositories.PickupLogRepository
+	Service  *services.PickupService
+	Importer *services.PickupImporter
 }
 
+const maxImportSize = 5 << 20
+
 type pickupRequest struct {
 	SubscriptionID string `json:"subscription_id"`
 	PickupDate     string `json:"pickup_date"`
 	Status         string `json:"status"`
 	Comment        string `json:"comment"`
 	Reason         string `json:"reason"`
+	Overwrite      bool   `json:"overwrite"`
 }
 
 func (h PickupLogHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -38,14 +45,7 @@ func (h PickupLogHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := services.NewID()
-	if err != nil {
-		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to create", RequestID: middleware.GetRequestID(r.Context())})
-		return
-	}
-
 	log := repositories.PickupLog{
-		ID:             id,
 		SubscriptionID: req.SubscriptionID,
 		PickupDate:     pickupDate,
 		Status:         req.Status,
@@ -57,12 +57,23 @@ func (h PickupLogHandler) Create(w http.ResponseWriter, r *http.Request) {
 		log.Reason = sql.NullString{String: req.Reason, Valid: true}
 	}
 
-	if err := h.Logs.Create(r.Context(), log); err != nil {
+	overwrite := req.Overwrite || r.URL.Query().Get("overwrite") == "true"
+	actorID, _ := middleware.UserIDFromContext(r.Context())
+	saved, created, err := h.Service.Record(r.Context(), log, overwrite, actorID)
+	if err != nil {
+		if errors.Is(err, services.ErrPickupDuplicate) {
+			response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "CONFLICT_DUPLICATE", Message: "pickup log for this subscription and date already exists", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
 
-	response.JSON(w, http.StatusCreated, log)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	response.JSON(w, status, saved)
 }
 
 func (h PickupLogHandler) HandleCollection(w http.ResponseWriter, r *http.Request) {
@@ -73,6 +84,70 @@ func (h PickupLogHandler) HandleCollection(w http.ResponseWriter, r *http.Reques
 	w.WriteHeader(http.StatusMethodNotAllowed)
 }
 
+func (h PickupLogHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1/admin/pickup-logs/import" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		h.Import(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/history") {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		h.History(w, r)
+		return
+	}
+	h.Update(w, r)
+}
+
+// Import accepts the CSV either as a multipart "file" field or as the raw body.
+func (h PickupLogHandler) Import(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var input io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "file required", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		defer file.Close()
+		input = file
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	actorID, _ := middleware.UserIDFromContext(r.Context())
+	summary, err := h.Importer.Import(r.Context(), input, dryRun, actorID)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	errs := make([]map[string]any, 0, len(summary.Errors))
+	for _, item := range summary.Errors {
+		errs = append(errs, map[string]any{"line": item.Line, "message": item.Message})
+	}
+	payload := map[string]any{
+		"dry_run": summary.DryRun,
+		"total":   summary.Total,
+		"created": summary.Created,
+		"updated": summary.Updated,
+		"failed":  len(summary.Errors),
+		"errors":  errs,
+	}
+
+	status := http.StatusOK
+	if len(summary.Errors) > 0 && !dryRun {
+		status = http.StatusUnprocessableEntity
+		payload["created"], payload["updated"] = 0, 0
+	}
+	response.JSON(w, status, payload)
+}
+
 func (h PickupLogHandler) Update(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/pickup-logs/")
 	if id == "" {
@@ -97,10 +172,26 @@ func (h PickupLogHandler) Update(w http.ResponseWriter, r *http.Request) {
 		log.Reason = sql.NullString{String: req.Reason, Valid: true}
 	}
 
-	if err := h.Logs.Update(r.Context(), log); err != nil {
+	actorID, _ := middleware.UserIDFromContext(r.Context())
+	log, err := h.Service.Update(r.Context(), log, actorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "pickup log not found", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
 
 	response.JSON(w, http.StatusOK, log)
 }
+
+func (h PickupLogHandler) History(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/pickup-logs/"), "/history")
+	items, err := h.Logs.ListHistory(r.Context(), id)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	response.JSON(w, http.StatusOK, map[string]any{"items": items})
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"nesta/internal/frequency"
 	"nesta/internal/http/handlers"
 	"nesta/internal/http/middleware"
 	"nesta/internal/http/response"
@@ -14,15 +15,18 @@ import (
 
 type PlanHandler struct {
 	Plans *repositories.PlanRepository
+	Audit *services.AuditService
 }
 
 type planRequest struct {
-	Name        string `json:"name"`
-	PriceCents  int    `json:"price_cents"`
-	Frequency   string `json:"frequency"`
-	BagsPerDay  int    `json:"bags_per_day"`
-	Description string `json:"description"`
-	IsActive    bool   `json:"is_active"`
+	Name            string `json:"name"`
+	PriceCents      int    `json:"price_cents"`
+	Frequency       string `json:"frequency"`
+	BagsPerDay      int    `json:"bags_per_day"`
+	Description     string `json:"description"`
+	IsActive        bool   `json:"is_active"`
+	MaxPauseDays    *int   `json:"max_pause_days"`
+	PickupFrequency string `json:"pickup_frequency"`
 }
 
 func (h PlanHandler) List(w http.ResponseWriter, r *http.Request) {
@@ -58,19 +62,42 @@ func (h PlanHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	freq, err := frequency.Parse(req.Frequency)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid frequency", Fields: map[string]string{"frequency": "expected weekly, monthly, quarterly, yearly or days:N"}, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	pickupFreq := "days:1"
+	if req.PickupFrequency != "" {
+		parsed, err := frequency.Parse(req.PickupFrequency)
+		if err != nil {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid pickup frequency", Fields: map[string]string{"pickup_frequency": "expected weekly, monthly, quarterly, yearly or days:N"}, RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		pickupFreq = parsed.String()
+	}
+
 	plan := repositories.Plan{
-		ID:         id,
-		Name:       req.Name,
-		PriceCents: req.PriceCents,
-		Frequency:  req.Frequency,
-		BagsPerDay: req.BagsPerDay,
-		IsActive:   req.IsActive,
+		ID:              id,
+		Name:            req.Name,
+		PriceCents:      req.PriceCents,
+		Frequency:       freq.String(),
+		BagsPerDay:      req.BagsPerDay,
+		IsActive:        req.IsActive,
+		PickupFrequency: pickupFreq,
 	}
 	if req.Description != "" {
 		plan.Description = sql.NullString{String: req.Description, Valid: true}
 	}
+	if req.MaxPauseDays != nil {
+		plan.MaxPauseDays = sql.NullInt64{Int64: int64(*req.MaxPauseDays), Valid: true}
+	}
 
-	if err := h.Plans.Create(r.Context(), plan); err != nil {
+	err = h.Audit.Do(r.Context(), "plan", id, services.AuditCreate, func(tx *sql.Tx) error {
+		return h.Plans.WithTx(tx).Create(r.Context(), plan)
+	})
+	if err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
@@ -91,19 +118,42 @@ func (h PlanHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	freq, err := frequency.Parse(req.Frequency)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid frequency", Fields: map[string]string{"frequency": "expected weekly, monthly, quarterly, yearly or days:N"}, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	pickupFreq := "days:1"
+	if req.PickupFrequency != "" {
+		parsed, err := frequency.Parse(req.PickupFrequency)
+		if err != nil {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid pickup frequency", Fields: map[string]string{"pickup_frequency": "expected weekly, monthly, quarterly, yearly or days:N"}, RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		pickupFreq = parsed.String()
+	}
+
 	plan := repositories.Plan{
-		ID:         id,
-		Name:       req.Name,
-		PriceCents: req.PriceCents,
-		Frequency:  req.Frequency,
-		BagsPerDay: req.BagsPerDay,
-		IsActive:   req.IsActive,
+		ID:              id,
+		Name:            req.Name,
+		PriceCents:      req.PriceCents,
+		Frequency:       freq.String(),
+		BagsPerDay:      req.BagsPerDay,
+		IsActive:        req.IsActive,
+		PickupFrequency: pickupFreq,
 	}
 	if req.Description != "" {
 		plan.Description = sql.NullString{String: req.Description, Valid: true}
 	}
+	if req.MaxPauseDays != nil {
+		plan.MaxPauseDays = sql.NullInt64{Int64: int64(*req.MaxPauseDays), Valid: true}
+	}
 
-	if err := h.Plans.Update(r.Context(), plan); err != nil {
+	err = h.Audit.Do(r.Context(), "plan", id, services.AuditUpdate, func(tx *sql.Tx) error {
+		return h.Plans.WithTx(tx).Update(r.Context(), plan)
+	})
+	if err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
@@ -14,6 +14,7 @@ import (
 
 type ProductHandler struct {
 	Products *repositories.ProductRepository
+	Audit    *services.AuditService
 }
 
 type productRequest struct {
@@ -52,7 +53,10 @@ func (h ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
 		product.CategoryID = sql.NullString{String: req.CategoryID, Valid: true}
 	}
 
-	if err := h.Products.Create(r.Context(), product); err != nil {
+	err = h.Audit.Do(r.Context(), "product", id, services.AuditCreate, func(tx *sql.Tx) error {
+		return h.Products.WithTx(tx).Create(r.Context(), product)
+	})
+	if err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
@@ -96,7 +100,10 @@ func (h ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
 		product.CategoryID = sql.NullString{String: req.CategoryID, Valid: true}
 	}
 
-	if err := h.Products.Update(r.Context(), product); err != nil {
+	err := h.Audit.Do(r.Context(), "product", id, services.AuditUpdate, func(tx *sql.Tx) error {
+		return h.Products.WithTx(tx).Update(r.Context(), product)
+	})
+	if err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"nesta/internal/auth"
+	"nesta/internal/http/handlers"
+	"nesta/internal/http/middleware"
+	"nesta/internal/http/response"
+	"nesta/internal/services"
+)
+
+type RoleHandler struct {
+	Roles *services.RoleService
+}
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+func (h RoleHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	roles := auth.Roles()
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		items = append(items, map[string]any{"role": name, "permissions": roles[name]})
+	}
+	response.JSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// HandleItem serves PUT (grant) and DELETE (back to user) on
+// /api/v1/admin/users/{userId}/role.
+func (h RoleHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	userID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/users/"), "/role")
+	if userID == "" || strings.Contains(userID, "/") {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "user not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	var role string
+	switch r.Method {
+	case http.MethodPut:
+		var req roleRequest
+		if err := handlers.DecodeJSON(r, &req); err != nil {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid payload", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		role = req.Role
+	case http.MethodDelete:
+		role = auth.RoleUser
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	actorID, _ := middleware.UserIDFromContext(r.Context())
+	previous, err := h.Roles.SetRole(r.Context(), actorID, userID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "user not found", RequestID: middleware.GetRequestID(r.Context())})
+		case errors.Is(err, services.ErrInvalidRole):
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), Fields: map[string]string{"role": "one of user, courier, support, admin, super_admin"}, RequestID: middleware.GetRequestID(r.Context())})
+		case errors.Is(err, services.ErrOwnRole), errors.Is(err, services.ErrLastSuperAdmin):
+			response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "CONFLICT", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		default:
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to update role", RequestID: middleware.GetRequestID(r.Context())})
+		}
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]any{"user_id": userID, "role": role, "previous_role": previous})
+}
@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"nesta/internal/http/middleware"
+	"nesta/internal/http/response"
+	"nesta/internal/services"
+)
+
+type SessionHandler struct {
+	Auth *services.AuthService
+}
+
+// HandleItem serves /api/v1/admin/users/{userId}/sessions (GET, DELETE) and
+// /api/v1/admin/users/{userId}/sessions/{sessionId} (DELETE).
+func (h SessionHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/users/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] != "sessions" {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	userID := parts[0]
+
+	if len(parts) == 3 {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := h.Auth.RevokeSession(r.Context(), userID, parts[2]); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "session not found", RequestID: middleware.GetRequestID(r.Context())})
+				return
+			}
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to revoke", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		response.JSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := h.Auth.Sessions(r.Context(), userID)
+		if err != nil {
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		items := make([]map[string]any, 0, len(sessions))
+		for _, session := range sessions {
+			items = append(items, map[string]any{
+				"id":           session.ID,
+				"device_name":  session.DeviceName.String,
+				"user_agent":   session.UserAgent.String,
+				"ip":           session.IP.String,
+				"created_at":   session.CreatedAt,
+				"last_used_at": session.LastUsedAt,
+				"expires_at":   session.ExpiresAt,
+			})
+		}
+		response.JSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodDelete:
+		revoked, err := h.Auth.RevokeAllSessions(r.Context(), userID)
+		if err != nil {
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to revoke", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		response.JSON(w, http.StatusOK, map[string]any{"status": "revoked", "revoked": revoked})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -1,8 +1,10 @@
 package admin
 
 import (
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"nesta/internal/http/handlers"
 	"nesta/internal/http/middleware"
@@ -18,6 +20,9 @@ type SubscriptionHandler struct {
 
 type subscriptionActionRequest struct {
 	Action string `json:"action"`
+	Reason string `json:"reason"`
+	From   string `json:"from"`
+	Until  string `json:"until"`
 }
 
 func (h SubscriptionHandler) List(w http.ResponseWriter, r *http.Request) {
@@ -50,23 +55,53 @@ func (h SubscriptionHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status := ""
+	adminID, _ := middleware.UserIDFromContext(r.Context())
+
+	var (
+		sub repositories.Subscription
+		err error
+	)
 	switch req.Action {
 	case "cancel":
-		status = "CANCELED"
+		sub, err = h.Service.Cancel(r.Context(), id, true, adminID)
 	case "pause":
-		status = "PAUSED"
+		var pause services.PauseRequest
+		if pause.From, pause.Until, err = parseDateRange(req.From, req.Until); err != nil {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid date", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		sub, err = h.Service.Pause(r.Context(), id, pause, adminID)
 	case "resume":
-		status = "ACTIVE"
+		sub, err = h.Service.Resume(r.Context(), id, adminID)
 	default:
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid action", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
-
-	if err := h.Service.UpdateStatus(r.Context(), id, status); err != nil {
+	if err != nil {
+		var transitionErr *services.TransitionError
+		if errors.As(err, &transitionErr) {
+			response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "CONFLICT", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
 
-	response.JSON(w, http.StatusOK, map[string]string{"status": status})
+	response.JSON(w, http.StatusOK, map[string]string{"status": sub.Status})
+}
+
+func parseDateRange(from, until string) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+	if from != "" {
+		if start, err = time.Parse("2006-01-02", from); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if until != "" {
+		if end, err = time.Parse("2006-01-02", until); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return start, end, nil
 }
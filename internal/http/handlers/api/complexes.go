@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -9,6 +10,7 @@ import (
 	"nesta/internal/http/handlers"
 	"nesta/internal/http/middleware"
 	"nesta/internal/http/response"
+	"nesta/internal/phone"
 	"nesta/internal/repositories"
 	"nesta/internal/services"
 )
@@ -17,7 +19,8 @@ type ComplexHandler struct {
 	Complexes *repositories.ComplexRepository
 	Requests  *repositories.ComplexRequestRepository
 	Service   *services.ComplexService
-	JWTSecret string
+	Keys      *auth.KeySet
+	Sessions  middleware.SessionChecker
 }
 
 type requestCreate struct {
@@ -59,14 +62,7 @@ func (h ComplexHandler) CreateRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authHeader := r.Header.Get("Authorization")
-	parts := strings.SplitN(authHeader, " ", 2)
-	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "unauthorized", RequestID: middleware.GetRequestID(r.Context())})
-		return
-	}
-	claims, err := auth.ParseToken(h.JWTSecret, parts[1])
-	if err != nil || claims.Subject == "" {
+	if _, err := middleware.Authenticate(r, h.Keys, h.Sessions); err != nil {
 		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "unauthorized", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
@@ -78,6 +74,10 @@ func (h ComplexHandler) CreateRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	request, _, err := h.Service.CreateRequest(r.Context(), complexID, req.Phone)
+	if errors.Is(err, phone.ErrInvalid) {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid phone", Fields: map[string]string{"phone": "expected phone number like +77011234567"}, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
 	if err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
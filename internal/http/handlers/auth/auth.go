@@ -1,16 +1,39 @@
 package auth
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
 
 	"nesta/internal/http/handlers"
 	"nesta/internal/http/middleware"
 	"nesta/internal/http/response"
+	"nesta/internal/ratelimit"
 	"nesta/internal/services"
+
+	"github.com/rs/zerolog"
 )
 
 type Handler struct {
-	Auth *services.AuthService
+	Auth   *services.AuthService
+	Logger zerolog.Logger
+	// DevMode exposes the OTP code in responses; only set in development.
+	DevMode        bool
+	Limiter        ratelimit.Limiter
+	Limits         Limits
+	TrustedProxies []netip.Prefix
+}
+
+type Limits struct {
+	SendPhone    ratelimit.Rule
+	SendIP       ratelimit.Rule
+	SendDevice   ratelimit.Rule
+	VerifyPhone  ratelimit.Rule
+	VerifyIP     ratelimit.Rule
+	VerifyDevice ratelimit.Rule
 }
 
 type sendOTPRequest struct {
@@ -18,12 +41,14 @@ type sendOTPRequest struct {
 }
 
 type verifyOTPRequest struct {
-	Phone string `json:"phone"`
-	Code  string `json:"code"`
+	Phone      string `json:"phone"`
+	Code       string `json:"code"`
+	DeviceName string `json:"device_name"`
 }
 
 type refreshRequest struct {
 	RefreshToken string `json:"refresh_token"`
+	DeviceName   string `json:"device_name"`
 }
 
 func (h Handler) SendOTP(w http.ResponseWriter, r *http.Request) {
@@ -33,23 +58,39 @@ func (h Handler) SendOTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.Auth.SendOTP(r.Context(), req.Phone)
+	phone, err := h.Auth.NormalizePhone(req.Phone)
 	if err != nil {
-		code := "VALIDATION_ERROR"
-		status := http.StatusBadRequest
-		if err.Error() == "rate limited" {
-			code = "RATE_LIMITED"
-			status = http.StatusTooManyRequests
-		}
-		response.ErrorJSON(w, status, response.Error{Code: code, Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), Fields: map[string]string{"phone": "expected phone number like +77011234567"}, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	if !h.allow(w, r, "otp_send", phone, h.Limits.SendPhone, h.Limits.SendIP, h.Limits.SendDevice) {
 		return
 	}
 
-	response.JSON(w, http.StatusOK, map[string]any{
+	result, err := h.Auth.SendOTP(r.Context(), phone)
+	if errors.Is(err, services.ErrOTPDelivery) {
+		h.Logger.Error().Err(err).Str("request_id", middleware.GetRequestID(r.Context())).Msg("otp delivery failed")
+		response.ErrorJSON(w, http.StatusBadGateway, response.Error{Code: "OTP_DELIVERY_FAILED", Message: "failed to send code", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	var limited *services.RateLimitError
+	if errors.As(err, &limited) {
+		writeRateLimited(w, r, limited.RetryAfter)
+		return
+	}
+	if err != nil {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	payload := map[string]any{
 		"status":     "sent",
 		"expires_at": result.ExpiresAt,
-		"dev_code":   result.Code,
-	})
+	}
+	if h.DevMode {
+		payload["dev_code"] = result.Code
+	}
+	response.JSON(w, http.StatusOK, payload)
 }
 
 func (h Handler) VerifyOTP(w http.ResponseWriter, r *http.Request) {
@@ -59,7 +100,16 @@ func (h Handler) VerifyOTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pair, err := h.Auth.VerifyOTP(r.Context(), req.Phone, req.Code)
+	phone, err := h.Auth.NormalizePhone(req.Phone)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), Fields: map[string]string{"phone": "expected phone number like +77011234567"}, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	if !h.allow(w, r, "otp_verify", phone, h.Limits.VerifyPhone, h.Limits.VerifyIP, h.Limits.VerifyDevice) {
+		return
+	}
+
+	pair, err := h.Auth.VerifyOTP(r.Context(), phone, req.Code, h.sessionInfo(r, req.DeviceName))
 	if err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
@@ -79,7 +129,10 @@ func (h Handler) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pair, err := h.Auth.Refresh(r.Context(), req.RefreshToken)
+	pair, err := h.Auth.Refresh(r.Context(), req.RefreshToken, h.sessionInfo(r, req.DeviceName))
+	if errors.Is(err, services.ErrRefreshReused) {
+		h.Logger.Warn().Str("request_id", middleware.GetRequestID(r.Context())).Str("ip", middleware.ClientIP(r, h.TrustedProxies)).Msg("refresh token reuse, family revoked")
+	}
 	if err != nil {
 		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
 		return
@@ -106,3 +159,54 @@ func (h Handler) Logout(w http.ResponseWriter, r *http.Request) {
 
 	response.JSON(w, http.StatusOK, map[string]string{"status": "logged_out"})
 }
+
+func (h Handler) sessionInfo(r *http.Request, deviceName string) services.SessionInfo {
+	return services.SessionInfo{
+		DeviceName: deviceName,
+		UserAgent:  r.UserAgent(),
+		IP:         middleware.ClientIP(r, h.TrustedProxies),
+	}
+}
+
+type limitCheck struct {
+	key  string
+	rule ratelimit.Rule
+}
+
+// allow applies the phone, client IP and X-Device-Id limits for action. Limiter
+// failures are logged and let the request through.
+func (h Handler) allow(w http.ResponseWriter, r *http.Request, action, phone string, phoneRule, ipRule, deviceRule ratelimit.Rule) bool {
+	if h.Limiter == nil {
+		return true
+	}
+
+	checks := []limitCheck{{key: action + ":ip:" + middleware.ClientIP(r, h.TrustedProxies), rule: ipRule}}
+	if phone != "" {
+		checks = append(checks, limitCheck{key: action + ":phone:" + phone, rule: phoneRule})
+	}
+	if device := r.Header.Get("X-Device-Id"); device != "" {
+		checks = append(checks, limitCheck{key: action + ":device:" + device, rule: deviceRule})
+	}
+
+	for _, check := range checks {
+		decision, err := h.Limiter.Allow(r.Context(), check.key, check.rule)
+		if err != nil {
+			h.Logger.Error().Err(err).Str("key", check.key).Msg("rate limiter failed")
+			continue
+		}
+		if !decision.Allowed {
+			writeRateLimited(w, r, decision.RetryAfter)
+			return false
+		}
+	}
+	return true
+}
+
+func writeRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	response.ErrorJSON(w, http.StatusTooManyRequests, response.Error{Code: "RATE_LIMITED", Message: "rate limited", RequestID: middleware.GetRequestID(r.Context())})
+}
@@ -0,0 +1,129 @@
+package courier
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"nesta/internal/http/handlers"
+	"nesta/internal/http/middleware"
+	"nesta/internal/http/response"
+	"nesta/internal/services"
+)
+
+type Handler struct {
+	Service *services.CourierService
+}
+
+type markRequest struct {
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+	Comment  string `json:"comment"`
+	PhotoRef string `json:"photo_ref"`
+}
+
+func (h Handler) Route(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	courierID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "unauthorized", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	date := time.Now()
+	if value := r.URL.Query().Get("date"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid date", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		date = parsed
+	}
+
+	route, err := h.Service.Route(r.Context(), courierID, date)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to load route", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	items := make([]map[string]any, 0, len(route))
+	for _, group := range route {
+		stops := make([]map[string]any, 0, len(group.Stops))
+		for _, stop := range group.Stops {
+			stops = append(stops, map[string]any{
+				"pickup_id":       stop.PickupID,
+				"subscription_id": stop.SubscriptionID,
+				"building":        stop.Building,
+				"entrance":        stop.Entrance,
+				"floor":           stop.Floor,
+				"apartment":       stop.Apartment,
+				"address":         stop.Address,
+				"time_window":     stop.TimeWindow,
+				"instructions":    stop.Instructions,
+				"status":          stop.Status,
+				"reason":          stop.Reason,
+			})
+		}
+		items = append(items, map[string]any{
+			"complex_id":   group.ComplexID,
+			"complex_name": group.ComplexName,
+			"stops":        stops,
+		})
+	}
+
+	response.JSON(w, http.StatusOK, map[string]any{"date": date.Format("2006-01-02"), "items": items})
+}
+
+func (h Handler) Mark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	courierID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "unauthorized", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/courier/pickups/")
+	if id == "" {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "pickup not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	var req markRequest
+	if err := handlers.DecodeJSON(r, &req); err != nil {
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid payload", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	log, err := h.Service.Mark(r.Context(), courierID, id, services.PickupMark{
+		Status:   req.Status,
+		Reason:   req.Reason,
+		Comment:  req.Comment,
+		PhotoRef: req.PhotoRef,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "pickup not found", RequestID: middleware.GetRequestID(r.Context())})
+		case errors.Is(err, services.ErrPickupCompleted), errors.Is(err, services.ErrPickupNotToday):
+			response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "CONFLICT", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		default:
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		}
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]any{
+		"pickup_id": log.ID,
+		"status":    log.Status,
+		"reason":    log.Reason.String,
+		"photo_ref": log.PhotoRef.String,
+	})
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"nesta/internal/auth"
+)
+
+type JWKSHandler struct {
+	Keys *auth.KeySet
+}
+
+// Serve publishes the public verification keys. Other services may cache the
+// set for a few minutes, so a new key must be listed before it signs tokens.
+func (h JWKSHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, map[string]any{"keys": h.Keys.JWKS()})
+}
@@ -1,75 +1,120 @@
 package payments
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"strings"
 
 	"nesta/internal/http/handlers"
 	"nesta/internal/http/middleware"
 	"nesta/internal/http/response"
+	"nesta/internal/payments"
 	"nesta/internal/services"
+
+	"github.com/rs/zerolog"
 )
 
 type Handler struct {
 	Payments *services.PaymentService
+	Logger   zerolog.Logger
 }
 
 type initRequest struct {
-	Type              string `json:"type"`
-	EntityID          string `json:"entity_id"`
-	Provider          string `json:"provider"`
-	ProviderPaymentID string `json:"provider_payment_id"`
-	AmountCents       int    `json:"amount_cents"`
+	Type     string `json:"type"`
+	EntityID string `json:"entity_id"`
+	Provider string `json:"provider"`
 }
 
-type webhookRequest struct {
-	ProviderPaymentID string `json:"provider_payment_id"`
-	Status            string `json:"status"`
-	Payload           any    `json:"payload"`
-}
+// Webhook bodies larger than this are rejected unread.
+const maxWebhookBody = 1 << 20
 
 func (h Handler) Init(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "unauthorized", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
 	var req initRequest
 	if err := handlers.DecodeJSON(r, &req); err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid payload", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
 
-	payment, err := h.Payments.Init(r.Context(), services.PaymentInitRequest{
-		Type:              req.Type,
-		EntityID:          req.EntityID,
-		Provider:          req.Provider,
-		ProviderPaymentID: req.ProviderPaymentID,
-		AmountCents:       req.AmountCents,
+	result, err := h.Payments.Init(r.Context(), services.PaymentInitRequest{
+		UserID:   userID,
+		Type:     req.Type,
+		EntityID: req.EntityID,
+		Provider: req.Provider,
 	})
-	if err != nil {
-		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+	switch {
+	case errors.Is(err, payments.ErrUnknownProvider):
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), Fields: map[string]string{"provider": "unknown provider"}, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case errors.Is(err, services.ErrPaymentType):
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), Fields: map[string]string{"type": "expected order or subscription"}, RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case errors.Is(err, services.ErrPaymentNotFound):
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case errors.Is(err, services.ErrPaymentNotPayable):
+		response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "CONFLICT", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case errors.Is(err, services.ErrPaymentInProgress):
+		response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "PAYMENT_IN_PROGRESS", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case err != nil:
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to init payment", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
 
-	response.JSON(w, http.StatusCreated, payment)
+	response.JSON(w, http.StatusCreated, map[string]any{"payment": result.Payment, "checkout": result.Checkout})
 }
 
 func (h Handler) Webhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
 	provider := strings.TrimPrefix(r.URL.Path, "/api/v1/payments/webhook/")
 	if provider == "" {
 		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "provider not found", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
 
-	var req webhookRequest
-	if err := handlers.DecodeJSON(r, &req); err != nil {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid payload", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
 
-	if err := h.Payments.HandleWebhook(r.Context(), services.PaymentWebhook{
-		Provider:          provider,
-		ProviderPaymentID: req.ProviderPaymentID,
-		Status:            req.Status,
-		Payload:           req.Payload,
-	}); err != nil {
-		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "PAYMENT_WEBHOOK_INVALID", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+	err = h.Payments.HandleWebhook(r.Context(), services.PaymentWebhook{
+		Provider: provider,
+		Header:   r.Header,
+		Body:     body,
+	})
+	switch {
+	case errors.Is(err, payments.ErrUnknownProvider):
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "provider not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case errors.Is(err, payments.ErrInvalidSignature):
+		h.Logger.Warn().Str("request_id", middleware.GetRequestID(r.Context())).Str("provider", provider).Msg("payment webhook with invalid signature")
+		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "INVALID_SIGNATURE", Message: "invalid signature", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case errors.Is(err, payments.ErrInvalidEvent):
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "PAYMENT_WEBHOOK_INVALID", Message: "invalid event", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case errors.Is(err, services.ErrPaymentUnknown):
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "PAYMENT_WEBHOOK_INVALID", Message: "payment not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case errors.Is(err, services.ErrPaymentAmount):
+		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "PAYMENT_WEBHOOK_INVALID", Message: "amount mismatch", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	case err != nil:
+		// The details are kept on the stored event.
+		h.Logger.Error().Err(err).Str("request_id", middleware.GetRequestID(r.Context())).Str("provider", provider).Msg("payment webhook failed")
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to process webhook", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
 
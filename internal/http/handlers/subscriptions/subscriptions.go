@@ -2,8 +2,10 @@ package subscriptions
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"nesta/internal/http/handlers"
 	"nesta/internal/http/middleware"
@@ -15,6 +17,9 @@ import (
 type Handler struct {
 	Service       *services.SubscriptionService
 	Subscriptions *repositories.SubscriptionRepository
+	Events        *repositories.SubscriptionEventRepository
+	Pauses        *repositories.SubscriptionPauseRepository
+	PlanChanges   *repositories.PlanChangeRepository
 }
 
 type createRequest struct {
@@ -26,7 +31,11 @@ type createRequest struct {
 }
 
 type actionRequest struct {
-	Action string `json:"action"`
+	Action   string `json:"action"`
+	From     string `json:"from"`
+	Until    string `json:"until"`
+	PlanID   string `json:"plan_id"`
+	Provider string `json:"provider"`
 }
 
 func (h Handler) Create(w http.ResponseWriter, r *http.Request) {
@@ -76,10 +85,27 @@ func (h Handler) ListMine(w http.ResponseWriter, r *http.Request) {
 	response.JSON(w, http.StatusOK, map[string]any{"items": subs})
 }
 
+func (h Handler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/history") {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		h.History(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPatch {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	h.Update(w, r)
+}
+
 func (h Handler) Update(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/api/v1/subscriptions/")
-	if id == "" {
-		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "subscription not found", RequestID: middleware.GetRequestID(r.Context())})
+	userID, ok := h.authorize(w, r, id)
+	if !ok {
 		return
 	}
 
@@ -89,23 +115,127 @@ func (h Handler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status := ""
+	if req.Action == "change_plan" {
+		result, err := h.Service.ChangePlan(r.Context(), id, req.PlanID, req.Provider)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		response.JSON(w, http.StatusOK, map[string]any{
+			"status":   result.Subscription.Status,
+			"plan_id":  result.Subscription.PlanID,
+			"change":   result.Change,
+			"payment":  result.Payment,
+			"checkout": result.Checkout,
+		})
+		return
+	}
+	if req.Action == "cancel_plan_change" {
+		change, err := h.Service.CancelPlanChange(r.Context(), id)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		response.JSON(w, http.StatusOK, map[string]any{"change": change})
+		return
+	}
+
+	var (
+		sub repositories.Subscription
+		err error
+	)
 	switch req.Action {
 	case "cancel":
-		status = "CANCELED"
+		sub, err = h.Service.Cancel(r.Context(), id, false, userID)
+	case "undo_cancel":
+		sub, err = h.Service.UndoCancel(r.Context(), id, userID)
 	case "pause":
-		status = "PAUSED"
+		pause, parseErr := parsePause(req.From, req.Until)
+		if parseErr != nil {
+			response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid date", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		sub, err = h.Service.Pause(r.Context(), id, pause, userID)
 	case "resume":
-		status = "ACTIVE"
+		sub, err = h.Service.Resume(r.Context(), id, userID)
 	default:
 		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: "invalid action", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
 
-	if err := h.Service.UpdateStatus(r.Context(), id, status); err != nil {
-		response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+	payload := map[string]any{"status": sub.Status, "cancel_at": nil}
+	if sub.CancelAt.Valid {
+		payload["cancel_at"] = sub.CancelAt.Time
+	}
+	response.JSON(w, http.StatusOK, payload)
+}
+
+func (h Handler) History(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/subscriptions/"), "/history")
+	if _, ok := h.authorize(w, r, id); !ok {
+		return
+	}
+
+	events, err := h.Events.ListBySubscription(r.Context(), id)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
 		return
 	}
+	pauses, err := h.Pauses.ListBySubscription(r.Context(), id)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	changes, err := h.PlanChanges.ListBySubscription(r.Context(), id)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]any{"items": events, "pauses": pauses, "plan_changes": changes})
+}
+
+func (h Handler) authorize(w http.ResponseWriter, r *http.Request, id string) (string, bool) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "unauthorized", RequestID: middleware.GetRequestID(r.Context())})
+		return "", false
+	}
+
+	sub, err := h.Subscriptions.Get(r.Context(), id)
+	if id == "" || err != nil || sub.UserID != userID {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "subscription not found", RequestID: middleware.GetRequestID(r.Context())})
+		return "", false
+	}
+	return userID, true
+}
 
-	response.JSON(w, http.StatusOK, map[string]string{"status": status})
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var transitionErr *services.TransitionError
+	if errors.As(err, &transitionErr) || errors.Is(err, services.ErrPlanChangePending) || errors.Is(err, services.ErrNoPendingPlanChange) {
+		response.ErrorJSON(w, http.StatusConflict, response.Error{Code: "CONFLICT", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	response.ErrorJSON(w, http.StatusBadRequest, response.Error{Code: "VALIDATION_ERROR", Message: err.Error(), RequestID: middleware.GetRequestID(r.Context())})
+}
+
+func parsePause(from, until string) (services.PauseRequest, error) {
+	var req services.PauseRequest
+	var err error
+	if from != "" {
+		if req.From, err = time.Parse("2006-01-02", from); err != nil {
+			return services.PauseRequest{}, err
+		}
+	}
+	if until != "" {
+		if req.Until, err = time.Parse("2006-01-02", until); err != nil {
+			return services.PauseRequest{}, err
+		}
+	}
+	return req, nil
 }
@@ -0,0 +1,44 @@
+package users
+
+import (
+	"net/http"
+
+	"nesta/internal/http/middleware"
+	"nesta/internal/http/response"
+	"nesta/internal/repositories"
+)
+
+type NotificationHandler struct {
+	Notifications *repositories.NotificationRepository
+}
+
+// List serves GET /api/v1/me/notifications, newest first.
+func (h NotificationHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "unauthorized", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	notifications, err := h.Notifications.ListByUser(r.Context(), userID, 50)
+	if err != nil {
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	items := make([]map[string]any, 0, len(notifications))
+	for _, item := range notifications {
+		items = append(items, map[string]any{
+			"id":         item.ID,
+			"kind":       item.Kind,
+			"entity":     item.Entity,
+			"entity_id":  item.EntityID,
+			"payload":    jsonRaw(item.PayloadRaw),
+			"created_at": item.CreatedAt,
+		})
+	}
+	response.JSON(w, http.StatusOK, map[string]any{"items": items})
+}
@@ -0,0 +1,91 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"nesta/internal/http/middleware"
+	"nesta/internal/http/response"
+	"nesta/internal/repositories"
+	"nesta/internal/services"
+)
+
+type SessionHandler struct {
+	Auth *services.AuthService
+}
+
+// HandleCollection serves GET (list) and DELETE (log out everywhere) on /api/v1/me/sessions.
+func (h SessionHandler) HandleCollection(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "unauthorized", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := h.Auth.Sessions(r.Context(), userID)
+		if err != nil {
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to list", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		current, _ := middleware.SessionIDFromContext(r.Context())
+		items := make([]map[string]any, 0, len(sessions))
+		for _, session := range sessions {
+			items = append(items, sessionJSON(session, current))
+		}
+		response.JSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodDelete:
+		revoked, err := h.Auth.RevokeAllSessions(r.Context(), userID)
+		if err != nil {
+			response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to revoke", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		response.JSON(w, http.StatusOK, map[string]any{"status": "revoked", "revoked": revoked})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h SessionHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "unauthorized", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	sessionID := strings.TrimPrefix(r.URL.Path, "/api/v1/me/sessions/")
+	if sessionID == "" || strings.Contains(sessionID, "/") {
+		response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "session not found", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+
+	if err := h.Auth.RevokeSession(r.Context(), userID, sessionID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			response.ErrorJSON(w, http.StatusNotFound, response.Error{Code: "NOT_FOUND", Message: "session not found", RequestID: middleware.GetRequestID(r.Context())})
+			return
+		}
+		response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: "failed to revoke", RequestID: middleware.GetRequestID(r.Context())})
+		return
+	}
+	response.JSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
+
+func sessionJSON(session repositories.Session, current string) map[string]any {
+	return map[string]any{
+		"id":           session.ID,
+		"device_name":  session.DeviceName.String,
+		"user_agent":   session.UserAgent.String,
+		"ip":           session.IP.String,
+		"created_at":   session.CreatedAt,
+		"last_used_at": session.LastUsedAt,
+		"expires_at":   session.ExpiresAt,
+		"current":      session.ID == current,
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+
+	"nesta/internal/audit"
+)
+
+// AuditActor marks the authenticated user as the acting admin so that the
+// mutations made while serving the request are written to the audit log.
+// It must run after Auth.
+func AuditActor(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := UserIDFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := audit.WithActor(r.Context(), audit.Actor{AdminID: userID, IP: ClientIP(r, trusted)})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -14,40 +15,76 @@ type contextKey string
 const (
 	contextKeyUserID contextKey = "user_id"
 	contextKeyRole   contextKey = "role"
+
+	contextKeySessionID contextKey = "session_id"
 )
 
-func Auth(secret string) func(http.Handler) http.Handler {
+// SessionChecker reports whether the session an access token was issued for
+// is still active.
+type SessionChecker interface {
+	SessionActive(ctx context.Context, sessionID string) (bool, error)
+}
+
+var errSessionLookup = errors.New("failed to check session")
+
+// Authenticate validates the bearer token of r. When sessions is set, tokens
+// bound to a revoked session are rejected; tokens issued before sessions
+// existed carry no sid and are accepted until they expire.
+func Authenticate(r *http.Request, keys *auth.KeySet, sessions SessionChecker) (auth.Claims, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return auth.Claims{}, errors.New("missing token")
+	}
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return auth.Claims{}, errors.New("invalid authorization header")
+	}
+
+	claims, err := keys.ParseToken(parts[1])
+	if err != nil || claims.Subject == "" {
+		return auth.Claims{}, errors.New("invalid token")
+	}
+
+	if sessions != nil && claims.Session != "" {
+		active, err := sessions.SessionActive(r.Context(), claims.Session)
+		if err != nil {
+			return auth.Claims{}, errSessionLookup
+		}
+		if !active {
+			return auth.Claims{}, errors.New("session revoked")
+		}
+	}
+	return claims, nil
+}
+
+func Auth(keys *auth.KeySet, sessions SessionChecker) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			header := r.Header.Get("Authorization")
-			if header == "" {
-				response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "missing token", RequestID: GetRequestID(r.Context())})
+			claims, err := Authenticate(r, keys, sessions)
+			if errors.Is(err, errSessionLookup) {
+				response.ErrorJSON(w, http.StatusInternalServerError, response.Error{Code: "INTERNAL_ERROR", Message: err.Error(), RequestID: GetRequestID(r.Context())})
 				return
 			}
-			parts := strings.SplitN(header, " ", 2)
-			if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-				response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "invalid authorization header", RequestID: GetRequestID(r.Context())})
-				return
-			}
-
-			claims, err := auth.ParseToken(secret, parts[1])
 			if err != nil {
-				response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: "invalid token", RequestID: GetRequestID(r.Context())})
+				response.ErrorJSON(w, http.StatusUnauthorized, response.Error{Code: "UNAUTHORIZED", Message: err.Error(), RequestID: GetRequestID(r.Context())})
 				return
 			}
 
 			ctx := context.WithValue(r.Context(), contextKeyUserID, claims.Subject)
 			ctx = context.WithValue(ctx, contextKeyRole, claims.Role)
+			ctx = context.WithValue(ctx, contextKeySessionID, claims.Session)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func RequireRole(role string) func(http.Handler) http.Handler {
+// RequirePermission lets the request through only if the token's role grants
+// permission (see auth.HasPermission).
+func RequirePermission(permission auth.Permission) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			current, _ := RoleFromContext(r.Context())
-			if current != role {
+			role, _ := RoleFromContext(r.Context())
+			if !auth.HasPermission(role, permission) {
 				response.ErrorJSON(w, http.StatusForbidden, response.Error{Code: "FORBIDDEN", Message: "insufficient permissions", RequestID: GetRequestID(r.Context())})
 				return
 			}
@@ -67,3 +104,9 @@ func RoleFromContext(ctx context.Context) (string, bool) {
 	role, ok := value.(string)
 	return role, ok && role != ""
 }
+
+func SessionIDFromContext(ctx context.Context) (string, bool) {
+	value := ctx.Value(contextKeySessionID)
+	id, ok := value.(string)
+	return id, ok && id != ""
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{name: "untrusted remote ignores header", remote: "203.0.113.7:5000", forwarded: "198.51.100.1", want: "203.0.113.7"},
+		{name: "trusted remote without header", remote: "10.0.0.2:5000", want: "10.0.0.2"},
+		{name: "trusted remote uses header", remote: "10.0.0.2:5000", forwarded: "198.51.100.1", want: "198.51.100.1"},
+		{name: "spoofed entries on the left", remote: "10.0.0.2:5000", forwarded: "1.2.3.4, 198.51.100.1", want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remote: "10.0.0.2:5000", forwarded: "198.51.100.1, 192.168.1.1, 10.0.0.3", want: "198.51.100.1"},
+		{name: "garbage stops the walk", remote: "10.0.0.2:5000", forwarded: "198.51.100.1, garbage", want: "10.0.0.2"},
+		{name: "only trusted entries", remote: "10.0.0.2:5000", forwarded: "10.0.0.4", want: "10.0.0.4"},
+		{name: "mapped ipv6 remote", remote: "[::ffff:203.0.113.7]:5000", want: "203.0.113.7"},
+		{name: "mapped ipv6 trusted remote", remote: "[::ffff:10.0.0.2]:5000", forwarded: "198.51.100.1", want: "198.51.100.1"},
+		{name: "remote without port", remote: "203.0.113.7", want: "203.0.113.7"},
+		{name: "unparsable remote", remote: "unknown", want: "unknown"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := ClientIP(r, trusted); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "10.0.0.0/8", want: 1},
+		{value: "10.1.2.3/8, ::1, 127.0.0.1,", want: 3},
+		{value: "10.0.0.0/33", wantErr: true},
+		{value: "not-an-ip", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTrustedProxies(tt.value)
+		if (err != nil) != tt.wantErr || len(got) != tt.want {
+			t.Errorf("ParseTrustedProxies(%q) = %v, %v", tt.value, got, err)
+		}
+	}
+}
@@ -12,8 +12,8 @@ func RequestID(next http.Handler) http.Handler {
 }
 
 func GetRequestID(ctx context.Context) string {
-	id := hlog.IDFromCtx(ctx)
-	if id == nil {
+	id, ok := hlog.IDFromCtx(ctx)
+	if !ok {
 		return ""
 	}
 	return id.String()
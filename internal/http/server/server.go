@@ -2,11 +2,15 @@ package server
 
 import (
 	"net/http"
+	"net/netip"
+	"strings"
 
+	"nesta/internal/auth"
 	"nesta/internal/http/handlers"
 	adminHandlers "nesta/internal/http/handlers/admin"
 	apiHandlers "nesta/internal/http/handlers/api"
 	authHandlers "nesta/internal/http/handlers/auth"
+	courierHandlers "nesta/internal/http/handlers/courier"
 	paymentHandlers "nesta/internal/http/handlers/payments"
 	storeHandlers "nesta/internal/http/handlers/store"
 	subscriptionHandlers "nesta/internal/http/handlers/subscriptions"
@@ -23,6 +27,7 @@ type Server struct {
 
 type Dependencies struct {
 	Health         handlers.HealthHandler
+	JWKS           handlers.JWKSHandler
 	Auth           authHandlers.Handler
 	Complexes      apiHandlers.ComplexHandler
 	Plans          apiHandlers.PlanHandler
@@ -38,13 +43,26 @@ type Dependencies struct {
 	AdminProducts  adminHandlers.ProductHandler
 	AdminOrders    adminHandlers.OrderHandler
 	AdminPickups   adminHandlers.PickupLogHandler
+	AdminCouriers  adminHandlers.CourierHandler
+	Courier        courierHandlers.Handler
+	MySessions     userHandlers.SessionHandler
+	MyNotices      userHandlers.NotificationHandler
+	AdminSessions  adminHandlers.SessionHandler
+	AdminRoles     adminHandlers.RoleHandler
+	AdminAudit     adminHandlers.AuditLogHandler
+	AdminPayEvents adminHandlers.PaymentEventHandler
+	AdminPayments  adminHandlers.PaymentHandler
+	Sessions       middleware.SessionChecker
+	TrustedProxies []netip.Prefix
 }
 
-func New(logger zerolog.Logger, deps Dependencies, jwtSecret string) *Server {
+func New(logger zerolog.Logger, deps Dependencies, keys *auth.KeySet) *Server {
 	mux := http.NewServeMux()
+	authenticated := middleware.Auth(keys, deps.Sessions)
 
 	mux.HandleFunc("/health", deps.Health.Health)
 	mux.HandleFunc("/ready", deps.Health.Ready)
+	mux.HandleFunc("/.well-known/jwks.json", deps.JWKS.Serve)
 
 	mux.HandleFunc("/api/v1/complexes", deps.Complexes.List)
 	mux.HandleFunc("/api/v1/complexes/", deps.Complexes.HandleItem)
@@ -56,38 +74,86 @@ func New(logger zerolog.Logger, deps Dependencies, jwtSecret string) *Server {
 	mux.HandleFunc("/api/v1/auth/refresh", deps.Auth.Refresh)
 	mux.HandleFunc("/api/v1/auth/logout", deps.Auth.Logout)
 
-	mux.Handle("/api/v1/me", middleware.Auth(jwtSecret)(http.HandlerFunc(deps.Users.Me)))
-	mux.Handle("/api/v1/subscriptions", middleware.Auth(jwtSecret)(http.HandlerFunc(deps.Subscriptions.Create)))
-	mux.Handle("/api/v1/subscriptions/me", middleware.Auth(jwtSecret)(http.HandlerFunc(deps.Subscriptions.ListMine)))
-	mux.Handle("/api/v1/subscriptions/", middleware.Auth(jwtSecret)(http.HandlerFunc(deps.Subscriptions.Update)))
-	mux.Handle("/api/v1/pickups/", middleware.Auth(jwtSecret)(http.HandlerFunc(deps.Pickups.ListBySubscription)))
+	mux.Handle("/api/v1/me", authenticated(http.HandlerFunc(deps.Users.Me)))
+	mux.Handle("/api/v1/me/sessions", authenticated(http.HandlerFunc(deps.MySessions.HandleCollection)))
+	mux.Handle("/api/v1/me/sessions/", authenticated(http.HandlerFunc(deps.MySessions.HandleItem)))
+	mux.Handle("/api/v1/me/notifications", authenticated(http.HandlerFunc(deps.MyNotices.List)))
+	mux.Handle("/api/v1/subscriptions", authenticated(http.HandlerFunc(deps.Subscriptions.Create)))
+	mux.Handle("/api/v1/subscriptions/me", authenticated(http.HandlerFunc(deps.Subscriptions.ListMine)))
+	mux.Handle("/api/v1/subscriptions/", authenticated(http.HandlerFunc(deps.Subscriptions.HandleItem)))
+	mux.Handle("/api/v1/pickups/", authenticated(http.HandlerFunc(deps.Pickups.ListBySubscription)))
 
 	mux.HandleFunc("/api/v1/products", deps.Products.List)
 	mux.HandleFunc("/api/v1/products/", deps.Products.Get)
 
-	mux.Handle("/api/v1/orders", middleware.Auth(jwtSecret)(http.HandlerFunc(deps.Orders.Create)))
-	mux.Handle("/api/v1/orders/me", middleware.Auth(jwtSecret)(http.HandlerFunc(deps.Orders.ListMine)))
-	mux.Handle("/api/v1/orders/", middleware.Auth(jwtSecret)(http.HandlerFunc(deps.Orders.Get)))
+	mux.Handle("/api/v1/orders", authenticated(http.HandlerFunc(deps.Orders.Create)))
+	mux.Handle("/api/v1/orders/me", authenticated(http.HandlerFunc(deps.Orders.ListMine)))
+	mux.Handle("/api/v1/orders/", authenticated(http.HandlerFunc(deps.Orders.Get)))
 
-	mux.Handle("/api/v1/payments/init", middleware.Auth(jwtSecret)(http.HandlerFunc(deps.Payments.Init)))
+	mux.Handle("/api/v1/payments/init", authenticated(http.HandlerFunc(deps.Payments.Init)))
 	mux.HandleFunc("/api/v1/payments/webhook/", deps.Payments.Webhook)
 
-	adminAuth := func(handler http.HandlerFunc) http.Handler {
-		return middleware.Auth(jwtSecret)(middleware.RequireRole("admin")(handler))
+	// protected requires read for safe methods and write for everything else.
+	protected := func(read, write auth.Permission, handler http.HandlerFunc) http.Handler {
+		readHandler := authenticated(middleware.RequirePermission(read)(handler))
+		writeHandler := authenticated(middleware.RequirePermission(write)(handler))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				readHandler.ServeHTTP(w, r)
+				return
+			}
+			writeHandler.ServeHTTP(w, r)
+		})
 	}
 
-	mux.Handle("/api/v1/admin/complexes", adminAuth(http.HandlerFunc(deps.AdminComplexes.HandleCollection)))
-	mux.Handle("/api/v1/admin/complexes/", adminAuth(http.HandlerFunc(deps.AdminComplexes.UpdateStatus)))
-	mux.Handle("/api/v1/admin/plans", adminAuth(http.HandlerFunc(deps.AdminPlans.HandleCollection)))
-	mux.Handle("/api/v1/admin/plans/", adminAuth(http.HandlerFunc(deps.AdminPlans.Update)))
-	mux.Handle("/api/v1/admin/subscriptions", adminAuth(http.HandlerFunc(deps.AdminSubs.HandleCollection)))
-	mux.Handle("/api/v1/admin/subscriptions/", adminAuth(http.HandlerFunc(deps.AdminSubs.Update)))
-	mux.Handle("/api/v1/admin/products", adminAuth(http.HandlerFunc(deps.AdminProducts.HandleCollection)))
-	mux.Handle("/api/v1/admin/products/", adminAuth(http.HandlerFunc(deps.AdminProducts.Update)))
-	mux.Handle("/api/v1/admin/orders", adminAuth(http.HandlerFunc(deps.AdminOrders.HandleCollection)))
-	mux.Handle("/api/v1/admin/orders/", adminAuth(http.HandlerFunc(deps.AdminOrders.Update)))
-	mux.Handle("/api/v1/admin/pickup-logs", adminAuth(http.HandlerFunc(deps.AdminPickups.HandleCollection)))
-	mux.Handle("/api/v1/admin/pickup-logs/", adminAuth(http.HandlerFunc(deps.AdminPickups.Update)))
+	mux.Handle("/api/v1/courier/route", protected(auth.PermPickupsMark, auth.PermPickupsMark, deps.Courier.Route))
+	mux.Handle("/api/v1/courier/pickups/", protected(auth.PermPickupsMark, auth.PermPickupsMark, deps.Courier.Mark))
+
+	// admin is protected plus the audit actor: mutations on admin routes are
+	// recorded in audit_logs, courier marks are not.
+	actor := middleware.AuditActor(deps.TrustedProxies)
+	admin := func(read, write auth.Permission, handler http.HandlerFunc) http.Handler {
+		return protected(read, write, actor(handler).ServeHTTP)
+	}
+
+	mux.Handle("/api/v1/admin/complexes", admin(auth.PermComplexesRead, auth.PermComplexesWrite, deps.AdminComplexes.HandleCollection))
+	mux.Handle("/api/v1/admin/complexes/", admin(auth.PermComplexesRead, auth.PermComplexesWrite, deps.AdminComplexes.HandleItem))
+	mux.Handle("/api/v1/admin/plans", admin(auth.PermPlansRead, auth.PermPlansWrite, deps.AdminPlans.HandleCollection))
+	mux.Handle("/api/v1/admin/plans/", admin(auth.PermPlansRead, auth.PermPlansWrite, deps.AdminPlans.Update))
+	mux.Handle("/api/v1/admin/subscriptions", admin(auth.PermSubscriptionsRead, auth.PermSubscriptionsWrite, deps.AdminSubs.HandleCollection))
+	mux.Handle("/api/v1/admin/subscriptions/", admin(auth.PermSubscriptionsRead, auth.PermSubscriptionsWrite, deps.AdminSubs.Update))
+	mux.Handle("/api/v1/admin/products", admin(auth.PermProductsRead, auth.PermProductsWrite, deps.AdminProducts.HandleCollection))
+	mux.Handle("/api/v1/admin/products/", admin(auth.PermProductsRead, auth.PermProductsWrite, deps.AdminProducts.Update))
+	mux.Handle("/api/v1/admin/orders", admin(auth.PermOrdersRead, auth.PermOrdersWrite, deps.AdminOrders.HandleCollection))
+	mux.Handle("/api/v1/admin/orders/", admin(auth.PermOrdersRead, auth.PermOrdersWrite, deps.AdminOrders.Update))
+	mux.Handle("/api/v1/admin/pickup-logs", admin(auth.PermPickupsRead, auth.PermPickupsWrite, deps.AdminPickups.HandleCollection))
+	mux.Handle("/api/v1/admin/pickup-logs/", admin(auth.PermPickupsRead, auth.PermPickupsWrite, deps.AdminPickups.HandleItem))
+	mux.Handle("/api/v1/admin/couriers/", admin(auth.PermCouriersRead, auth.PermCouriersWrite, deps.AdminCouriers.HandleItem))
+	mux.Handle("/api/v1/admin/roles", admin(auth.PermRolesWrite, auth.PermRolesWrite, deps.AdminRoles.List))
+
+	mux.Handle("/api/v1/admin/payment-events", admin(auth.PermPaymentsRead, auth.PermPaymentsRead, deps.AdminPayEvents.List))
+	mux.Handle("/api/v1/admin/payment-events/", admin(auth.PermPaymentsRead, auth.PermPaymentsWrite, deps.AdminPayEvents.HandleItem))
+	mux.Handle("/api/v1/admin/payments/refund-required", admin(auth.PermPaymentsRead, auth.PermPaymentsRead, deps.AdminPayments.RefundRequired))
+	paymentRefund := admin(auth.PermPaymentsRead, auth.PermOrdersRefund, deps.AdminPayments.HandleItem)
+	paymentConfirm := admin(auth.PermPaymentsRead, auth.PermPaymentsWrite, deps.AdminPayments.Confirm)
+	mux.Handle("/api/v1/admin/payments/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/confirm") {
+			paymentConfirm.ServeHTTP(w, r)
+			return
+		}
+		paymentRefund.ServeHTTP(w, r)
+	}))
+	mux.Handle("/api/v1/admin/audit-logs", admin(auth.PermAuditRead, auth.PermAuditRead, deps.AdminAudit.List))
+
+	userSessions := admin(auth.PermSessionsRead, auth.PermSessionsWrite, deps.AdminSessions.HandleItem)
+	userRole := admin(auth.PermRolesWrite, auth.PermRolesWrite, deps.AdminRoles.HandleItem)
+	mux.Handle("/api/v1/admin/users/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/role") {
+			userRole.ServeHTTP(w, r)
+			return
+		}
+		userSessions.ServeHTTP(w, r)
+	}))
 
 	return &Server{mux: mux, logger: logger}
 }
@@ -0,0 +1,261 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// CloudPayments pays through the CloudPayments widget. Notifications must be
+// configured in JSON format; each one is signed with HMAC-SHA256 of the raw
+// body under the API secret, base64 encoded in the Content-HMAC header.
+type CloudPayments struct {
+	PublicID  string
+	APISecret string
+	BaseURL   string
+	Client    *http.Client
+}
+
+func NewCloudPayments(publicID, apiSecret, baseURL string) *CloudPayments {
+	return &CloudPayments{PublicID: publicID, APISecret: apiSecret, BaseURL: baseURL, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type cloudPaymentsNotification struct {
+	TransactionID int64       `json:"TransactionId"`
+	InvoiceID     string      `json:"InvoiceId"`
+	Amount        json.Number `json:"Amount"`
+	Currency      string      `json:"Currency"`
+	Status        string      `json:"Status"`
+	OperationType string      `json:"OperationType"`
+	AccountID     string      `json:"AccountId"`
+	Token         string      `json:"Token"`
+}
+
+func (p *CloudPayments) Name() string {
+	return "cloudpayments"
+}
+
+func (p *CloudPayments) CreateCheckout(ctx context.Context, req CheckoutRequest) (Checkout, error) {
+	return Checkout{Params: map[string]any{
+		"publicId":    p.PublicID,
+		"invoiceId":   req.ProviderPaymentID,
+		"amount":      json.Number(FormatAmount(req.AmountCents)),
+		"currency":    req.Currency,
+		"description": req.Description,
+		"accountId":   req.AccountID,
+	}}, nil
+}
+
+func (p *CloudPayments) VerifyWebhook(header http.Header, body []byte) error {
+	signature := header.Get("Content-HMAC")
+	if signature == "" {
+		signature = header.Get("X-Content-HMAC")
+	}
+	got, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || signature == "" {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(p.APISecret))
+	_, _ = mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (p *CloudPayments) ParseEvent(body []byte) (Event, error) {
+	var notification cloudPaymentsNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return Event{}, err
+	}
+	if notification.InvoiceID == "" || notification.TransactionID == 0 {
+		return Event{}, errors.New("InvoiceId and TransactionId required")
+	}
+	amount, err := ParseAmount(notification.Amount.String())
+	if err != nil {
+		return Event{}, err
+	}
+
+	status := StatusPending
+	switch {
+	case notification.OperationType == "Refund":
+		status = StatusRefunded
+	case notification.Status == "Completed":
+		status = StatusPaid
+	case notification.Status == "Declined" || notification.Status == "Cancelled":
+		status = StatusFailed
+	}
+
+	// A transaction is notified once per state, so transaction and status
+	// together identify the notification.
+	id := fmt.Sprintf("%s:%d:%s", notification.OperationType, notification.TransactionID, notification.Status)
+	event := Event{ID: id, ProviderPaymentID: notification.InvoiceID, Status: status, AmountCents: amount, Payload: body}
+	if status == StatusRefunded {
+		// A refund is a transaction of its own, the one the refund API returns.
+		event.RefundID = strconv.FormatInt(notification.TransactionID, 10)
+	}
+	return event, nil
+}
+
+// Refund refunds by the CloudPayments transaction id taken from the stored
+// notification payload.
+func (p *CloudPayments) Refund(ctx context.Context, req RefundRequest) (Refund, error) {
+	var notification cloudPaymentsNotification
+	if err := json.Unmarshal(req.Payload, &notification); err != nil || notification.TransactionID == 0 {
+		return Refund{}, errors.New("cloudpayments transaction id unknown")
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"TransactionId": notification.TransactionID,
+		"Amount":        json.Number(FormatAmount(req.AmountCents)),
+	})
+	if err != nil {
+		return Refund{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/payments/refund", bytes.NewReader(body))
+	if err != nil {
+		return Refund{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	// CloudPayments answers a repeated X-Request-ID with the first result.
+	httpReq.Header.Set("X-Request-ID", req.RefundID)
+	httpReq.SetBasicAuth(p.PublicID, p.APISecret)
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return Refund{}, err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return Refund{}, fmt.Errorf("cloudpayments returned %d: %s", resp.StatusCode, bytes.TrimSpace(raw))
+	}
+
+	var result struct {
+		Success bool   `json:"Success"`
+		Message string `json:"Message"`
+		Model   struct {
+			TransactionID int64 `json:"TransactionId"`
+		} `json:"Model"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return Refund{}, err
+	}
+	if !result.Success {
+		return Refund{}, fmt.Errorf("%w: cloudpayments refund failed: %s", ErrDeclined, result.Message)
+	}
+	return Refund{ProviderRefundID: strconv.FormatInt(result.Model.TransactionID, 10), Status: StatusRefunded}, nil
+}
+
+// FindPayment looks the payment up by InvoiceId. The returned payload has
+// the same shape as a notification, so refunds work from it as well.
+func (p *CloudPayments) FindPayment(ctx context.Context, providerPaymentID string) (Settlement, error) {
+	body, err := json.Marshal(map[string]string{"InvoiceId": providerPaymentID})
+	if err != nil {
+		return Settlement{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/v2/payments/find", bytes.NewReader(body))
+	if err != nil {
+		return Settlement{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.SetBasicAuth(p.PublicID, p.APISecret)
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return Settlement{}, err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return Settlement{}, fmt.Errorf("cloudpayments returned %d: %s", resp.StatusCode, bytes.TrimSpace(raw))
+	}
+
+	var result struct {
+		Success bool            `json:"Success"`
+		Message string          `json:"Message"`
+		Model   json.RawMessage `json:"Model"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return Settlement{}, err
+	}
+	if !result.Success && (len(result.Model) == 0 || string(result.Model) == "null") {
+		return Settlement{}, fmt.Errorf("%w: %s", ErrNotFound, result.Message)
+	}
+
+	var model cloudPaymentsNotification
+	if err := json.Unmarshal(result.Model, &model); err != nil {
+		return Settlement{}, err
+	}
+	amount, err := ParseAmount(model.Amount.String())
+	if err != nil {
+		return Settlement{}, err
+	}
+	status := StatusPending
+	switch model.Status {
+	case "Completed":
+		status = StatusPaid
+	case "Declined", "Cancelled":
+		status = StatusFailed
+	}
+	return Settlement{ProviderPaymentID: providerPaymentID, Status: status, AmountCents: amount, Payload: result.Model}, nil
+}
+
+// Charge pays by the card token CloudPayments sends in the notification of a
+// payment made with the card saved (recurring payments must be enabled).
+func (p *CloudPayments) Charge(ctx context.Context, req ChargeRequest) error {
+	var notification cloudPaymentsNotification
+	if err := json.Unmarshal(req.Payload, &notification); err != nil || notification.Token == "" || notification.AccountID == "" {
+		return ErrNotSupported
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"Amount":      json.Number(FormatAmount(req.AmountCents)),
+		"Currency":    req.Currency,
+		"AccountId":   notification.AccountID,
+		"Token":       notification.Token,
+		"InvoiceId":   req.ProviderPaymentID,
+		"Description": req.Description,
+	})
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/payments/tokens/charge", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.SetBasicAuth(p.PublicID, p.APISecret)
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("cloudpayments returned %d: %s", resp.StatusCode, bytes.TrimSpace(raw))
+	}
+
+	var result struct {
+		Success bool   `json:"Success"`
+		Message string `json:"Message"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return err
+	}
+	// A declined charge is also notified, so the payment is failed by the webhook.
+	if !result.Success && result.Message != "" {
+		return fmt.Errorf("cloudpayments charge failed: %s", result.Message)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Fake is a local provider for development and tests. Webhooks are JSON
+// {"event_id", "provider_payment_id", "status", "amount_cents"} signed with
+// hex HMAC-SHA256 of the body in X-Fake-Signature; Sign produces it.
+// Refunds and charges are kept in memory, and setting Err makes them fail.
+// A refund repeated with the same RefundID is recorded once.
+type Fake struct {
+	Secret string
+	Err    error
+
+	mu      sync.Mutex
+	refunds []RefundRequest
+	charges []ChargeRequest
+}
+
+type fakeEvent struct {
+	EventID           string `json:"event_id"`
+	ProviderPaymentID string `json:"provider_payment_id"`
+	Status            string `json:"status"`
+	AmountCents       int    `json:"amount_cents"`
+	RefundID          string `json:"refund_id"`
+}
+
+func (p *Fake) Name() string {
+	return "fake"
+}
+
+func (p *Fake) CreateCheckout(ctx context.Context, req CheckoutRequest) (Checkout, error) {
+	return Checkout{URL: "https://fake.invalid/checkout/" + req.ProviderPaymentID}, nil
+}
+
+func (p *Fake) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(p.Secret))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *Fake) VerifyWebhook(header http.Header, body []byte) error {
+	got, err := hex.DecodeString(header.Get("X-Fake-Signature"))
+	if err != nil || len(got) == 0 {
+		return ErrInvalidSignature
+	}
+	want, _ := hex.DecodeString(p.Sign(body))
+	if !hmac.Equal(got, want) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (p *Fake) ParseEvent(body []byte) (Event, error) {
+	var event fakeEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return Event{}, err
+	}
+	if event.EventID == "" || event.ProviderPaymentID == "" {
+		return Event{}, errors.New("event_id and provider_payment_id required")
+	}
+	switch event.Status {
+	case StatusPending, StatusPaid, StatusFailed, StatusRefunded:
+	default:
+		return Event{}, errors.New("invalid status")
+	}
+	return Event{ID: event.EventID, ProviderPaymentID: event.ProviderPaymentID, Status: event.Status, AmountCents: event.AmountCents, RefundID: event.RefundID, Payload: body}, nil
+}
+
+func (p *Fake) Refund(ctx context.Context, req RefundRequest) (Refund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Err != nil {
+		return Refund{}, p.Err
+	}
+	for _, done := range p.refunds {
+		if done.RefundID == req.RefundID {
+			return Refund{ProviderRefundID: "fake-" + req.RefundID, Status: StatusRefunded}, nil
+		}
+	}
+	p.refunds = append(p.refunds, req)
+	return Refund{ProviderRefundID: "fake-" + req.RefundID, Status: StatusRefunded}, nil
+}
+
+func (p *Fake) Refunds() []RefundRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]RefundRequest{}, p.refunds...)
+}
+
+func (p *Fake) Charge(ctx context.Context, req ChargeRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Err != nil {
+		return p.Err
+	}
+	p.charges = append(p.charges, req)
+	return nil
+}
+
+func (p *Fake) Charges() []ChargeRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ChargeRequest{}, p.charges...)
+}
@@ -0,0 +1,32 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Manual is for payments taken outside any provider, e.g. cash or bank
+// transfer confirmed by an admin. It has no checkout page and accepts no
+// webhooks; refunds are likewise made by hand and only recorded.
+type Manual struct{}
+
+func (Manual) Name() string {
+	return "manual"
+}
+
+func (Manual) CreateCheckout(ctx context.Context, req CheckoutRequest) (Checkout, error) {
+	return Checkout{}, nil
+}
+
+func (Manual) VerifyWebhook(header http.Header, body []byte) error {
+	return ErrInvalidSignature
+}
+
+func (Manual) ParseEvent(body []byte) (Event, error) {
+	return Event{}, errors.New("manual payments have no webhooks")
+}
+
+func (Manual) Refund(ctx context.Context, req RefundRequest) (Refund, error) {
+	return Refund{ProviderRefundID: req.RefundID, Status: StatusRefunded}, nil
+}
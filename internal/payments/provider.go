@@ -0,0 +1,181 @@
+// Package payments holds the payment provider adapters. Services only talk to
+// the Provider interface; adapters are looked up by name in a Registry.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Normalized payment statuses every adapter maps its own states to.
+const (
+	StatusPending  = "PENDING"
+	StatusPaid     = "PAID"
+	StatusFailed   = "FAILED"
+	StatusRefunded = "REFUNDED"
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown payment provider")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidEvent     = errors.New("invalid webhook event")
+	ErrNotSupported     = errors.New("not supported by provider")
+	ErrNotFound         = errors.New("payment not found at provider")
+	// ErrDeclined means the provider definitely refused the operation, as
+	// opposed to a timeout after which its outcome is unknown.
+	ErrDeclined = errors.New("declined by provider")
+)
+
+type CheckoutRequest struct {
+	PaymentID         string
+	ProviderPaymentID string
+	AmountCents       int
+	Currency          string
+	Description       string
+	// AccountID is our user id, for providers that save cards per account.
+	AccountID string
+}
+
+// Checkout tells the client how to pay: a URL to redirect to, parameters for
+// a provider widget, or both.
+type Checkout struct {
+	URL    string         `json:"url,omitempty"`
+	Params map[string]any `json:"params,omitempty"`
+}
+
+// Event is a webhook reduced to what the services act on. Payload is the
+// original notification as JSON, kept on the payment for later reference.
+type Event struct {
+	ID                string
+	ProviderPaymentID string
+	Status            string
+	AmountCents       int
+	// RefundID is the provider's id of the refund a REFUNDED event is about,
+	// the same id its Refund returns as ProviderRefundID.
+	RefundID string
+	Payload  []byte
+}
+
+type RefundRequest struct {
+	RefundID          string
+	ProviderPaymentID string
+	AmountCents       int
+	Currency          string
+	// Payload is the payload stored with the payment, for providers that
+	// refund by their own transaction id rather than ours.
+	Payload []byte
+}
+
+type Refund struct {
+	ProviderRefundID string
+	Status           string
+}
+
+type ChargeRequest struct {
+	PaymentID         string
+	ProviderPaymentID string
+	AmountCents       int
+	Currency          string
+	Description       string
+	// Payload is the payload of an earlier paid payment of the same user,
+	// which carries the provider's token for the saved card.
+	Payload []byte
+}
+
+// Charger is implemented by providers that can charge a saved card without
+// the user, e.g. for subscription renewals. The result arrives as a webhook
+// like for any other payment; ErrNotSupported means no card is saved.
+type Charger interface {
+	Charge(ctx context.Context, req ChargeRequest) error
+}
+
+// Settlement is a payment as the provider sees it, taken from a settlement
+// report or looked up through the provider API.
+type Settlement struct {
+	ProviderPaymentID string
+	Status            string
+	AmountCents       int
+	Payload           []byte
+}
+
+// Finder is implemented by providers that can look a payment up by our
+// provider_payment_id. It returns ErrNotFound for unknown payments.
+type Finder interface {
+	FindPayment(ctx context.Context, providerPaymentID string) (Settlement, error)
+}
+
+type Provider interface {
+	Name() string
+	CreateCheckout(ctx context.Context, req CheckoutRequest) (Checkout, error)
+	// VerifyWebhook checks the signature of a raw webhook request and
+	// returns ErrInvalidSignature when it does not match.
+	VerifyWebhook(header http.Header, body []byte) error
+	ParseEvent(body []byte) (Event, error)
+	Refund(ctx context.Context, req RefundRequest) (Refund, error)
+}
+
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	registry := &Registry{providers: map[string]Provider{}}
+	for _, provider := range providers {
+		registry.providers[provider.Name()] = provider
+	}
+	return registry
+}
+
+// Get returns ErrUnknownProvider for names that are not configured.
+func (r *Registry) Get(name string) (Provider, error) {
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatAmount renders cents as a decimal amount, 19900 -> "199.00".
+func FormatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// ParseAmount reads a decimal amount with at most two fractional digits.
+func ParseAmount(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	whole, fraction, _ := strings.Cut(value, ".")
+	if len(fraction) > 2 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+	units, err := strconv.Atoi(whole)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	cents, err := strconv.Atoi(fraction)
+	if err != nil || strings.ContainsAny(fraction, "+-") {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	if strings.HasPrefix(whole, "-") {
+		return units*100 - cents, nil
+	}
+	return units*100 + cents, nil
+}
@@ -0,0 +1,128 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "199", want: 19900},
+		{value: "199.00", want: 19900},
+		{value: " 1.5 ", want: 150},
+		{value: "1.", want: 100},
+		{value: "0.05", want: 5},
+		{value: "-1.50", want: -150},
+		{value: "-0.50", want: -50},
+		{value: "1.234", wantErr: true},
+		{value: "abc", wantErr: true},
+		{value: "", wantErr: true},
+		{value: ".5", wantErr: true},
+		{value: "1.-5", wantErr: true},
+		{value: "1.+5", wantErr: true},
+		{value: "1,50", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseAmount(%q) = %d, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseAmount(%q) = %d, %v; want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		cents int
+		want  string
+	}{
+		{cents: 19900, want: "199.00"},
+		{cents: 5, want: "0.05"},
+		{cents: 0, want: "0.00"},
+		{cents: -150, want: "-1.50"},
+		{cents: -50, want: "-0.50"},
+	}
+	for _, tt := range tests {
+		got := FormatAmount(tt.cents)
+		if got != tt.want {
+			t.Errorf("FormatAmount(%d) = %q, want %q", tt.cents, got, tt.want)
+		}
+		if back, err := ParseAmount(got); err != nil || back != tt.cents {
+			t.Errorf("ParseAmount(%q) = %d, %v; want %d", got, back, err, tt.cents)
+		}
+	}
+}
+
+func TestCloudPaymentsVerifyWebhook(t *testing.T) {
+	p := NewCloudPayments("pk_test", "secret", "")
+	body := []byte(`{"TransactionId":1}`)
+	sign := func(secret string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		wantErr bool
+	}{
+		{name: "Content-HMAC", header: "Content-HMAC", value: sign("secret", body)},
+		{name: "X-Content-HMAC", header: "X-Content-HMAC", value: sign("secret", body)},
+		{name: "missing", wantErr: true},
+		{name: "not base64", header: "Content-HMAC", value: "%%%", wantErr: true},
+		{name: "wrong secret", header: "Content-HMAC", value: sign("other", body), wantErr: true},
+		{name: "other body", header: "Content-HMAC", value: sign("secret", []byte(`{}`)), wantErr: true},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.header != "" {
+			header.Set(tt.header, tt.value)
+		}
+		err := p.VerifyWebhook(header, body)
+		if tt.wantErr && !errors.Is(err, ErrInvalidSignature) || !tt.wantErr && err != nil {
+			t.Errorf("%s: VerifyWebhook = %v", tt.name, err)
+		}
+	}
+}
+
+func TestFakeVerifyWebhook(t *testing.T) {
+	p := &Fake{Secret: "secret"}
+	body := []byte(`{"event_id":"e1"}`)
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "valid", value: p.Sign(body)},
+		{name: "missing", wantErr: true},
+		{name: "not hex", value: "zz", wantErr: true},
+		{name: "wrong secret", value: (&Fake{Secret: "other"}).Sign(body), wantErr: true},
+		{name: "other body", value: p.Sign([]byte(`{}`)), wantErr: true},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.value != "" {
+			header.Set("X-Fake-Signature", tt.value)
+		}
+		err := p.VerifyWebhook(header, body)
+		if tt.wantErr && !errors.Is(err, ErrInvalidSignature) || !tt.wantErr && err != nil {
+			t.Errorf("%s: VerifyWebhook = %v", tt.name, err)
+		}
+	}
+}
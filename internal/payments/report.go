@@ -0,0 +1,88 @@
+package payments
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type reportRow struct {
+	ProviderPaymentID string      `json:"provider_payment_id"`
+	Status            string      `json:"status"`
+	Amount            json.Number `json:"amount"`
+}
+
+// ParseReport reads a settlement report already converted to our statuses.
+// CSV needs a header with provider_payment_id, status and amount columns;
+// JSON is an array of objects with the same keys. Amounts are decimal, as
+// providers report them ("199.00").
+func ParseReport(r io.Reader, format string) ([]Settlement, error) {
+	var rows []reportRow
+	switch format {
+	case "json":
+		if err := json.NewDecoder(r).Decode(&rows); err != nil {
+			return nil, err
+		}
+	case "csv":
+		var err error
+		if rows, err = readCSVReport(r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown report format %q", format)
+	}
+
+	settlements := make([]Settlement, 0, len(rows))
+	for i, row := range rows {
+		status := strings.ToUpper(strings.TrimSpace(row.Status))
+		switch status {
+		case StatusPending, StatusPaid, StatusFailed, StatusRefunded:
+		default:
+			return nil, fmt.Errorf("row %d: invalid status %q", i+1, row.Status)
+		}
+		if row.ProviderPaymentID == "" {
+			return nil, fmt.Errorf("row %d: provider_payment_id required", i+1)
+		}
+		amount, err := ParseAmount(row.Amount.String())
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		settlements = append(settlements, Settlement{ProviderPaymentID: strings.TrimSpace(row.ProviderPaymentID), Status: status, AmountCents: amount})
+	}
+	return settlements, nil
+}
+
+func readCSVReport(r io.Reader) ([]reportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty report")
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"provider_payment_id", "status", "amount"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("column %q missing", name)
+		}
+	}
+
+	rows := make([]reportRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		rows = append(rows, reportRow{
+			ProviderPaymentID: record[columns["provider_payment_id"]],
+			Status:            record[columns["status"]],
+			Amount:            json.Number(record[columns["amount"]]),
+		})
+	}
+	return rows, nil
+}
@@ -0,0 +1,82 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid phone")
+
+type country struct {
+	code     string
+	trunk    string
+	national int
+}
+
+// Countries whose local formats Normalize understands. Kazakhstan and Russia
+// share +7 and the 8 trunk prefix.
+var countries = map[string]country{
+	"KZ": {code: "7", trunk: "8", national: 10},
+	"RU": {code: "7", trunk: "8", national: 10},
+}
+
+func Supported(defaultCountry string) bool {
+	_, ok := countries[strings.ToUpper(defaultCountry)]
+	return ok
+}
+
+// Normalize returns the number in E.164 ("+77011234567"). Numbers without a
+// country code are read with the rules of defaultCountry.
+func Normalize(raw, defaultCountry string) (string, error) {
+	value := strings.TrimSpace(raw)
+	international := false
+	switch {
+	case strings.HasPrefix(value, "+"):
+		international = true
+		value = value[1:]
+	case strings.HasPrefix(value, "00"):
+		international = true
+		value = value[2:]
+	}
+
+	digits := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c == ' ' || c == '-' || c == '(' || c == ')' || c == '.':
+		default:
+			return "", ErrInvalid
+		}
+	}
+	number := string(digits)
+
+	if international {
+		return validate(number)
+	}
+
+	local, ok := countries[strings.ToUpper(defaultCountry)]
+	if !ok {
+		return "", ErrInvalid
+	}
+	switch {
+	case len(number) == local.national:
+		return validate(local.code + number)
+	case len(number) == len(local.code)+local.national && strings.HasPrefix(number, local.code):
+		return validate(number)
+	case len(number) == len(local.trunk)+local.national && strings.HasPrefix(number, local.trunk):
+		return validate(local.code + number[len(local.trunk):])
+	}
+	return "", ErrInvalid
+}
+
+func validate(number string) (string, error) {
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrInvalid
+	}
+	if number[0] == '7' && len(number) != 11 {
+		return "", ErrInvalid
+	}
+	return "+" + number, nil
+}
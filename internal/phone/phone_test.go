@@ -0,0 +1,42 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw     string
+		country string
+		want    string
+		wantErr bool
+	}{
+		{raw: "+7 (701) 123-45-67", country: "KZ", want: "+77011234567"},
+		{raw: "87011234567", country: "KZ", want: "+77011234567"},
+		{raw: "77011234567", country: "KZ", want: "+77011234567"},
+		{raw: "7011234567", country: "KZ", want: "+77011234567"},
+		{raw: "8 (916) 123.45.67", country: "ru", want: "+79161234567"},
+		{raw: "00 7 701 123 45 67", country: "KZ", want: "+77011234567"},
+		{raw: "  +44 20 7946 0958 ", country: "KZ", want: "+442079460958"},
+		{raw: "+7701123456", country: "KZ", wantErr: true},
+		{raw: "+0123456789", country: "KZ", wantErr: true},
+		{raw: "+7701abc4567", country: "KZ", wantErr: true},
+		{raw: "12345", country: "KZ", wantErr: true},
+		{raw: "97011234567", country: "KZ", wantErr: true},
+		{raw: "7011234567", country: "US", wantErr: true},
+		{raw: "", country: "KZ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw, tt.country)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("Normalize(%q, %q) = %q, %v; want ErrInvalid", tt.raw, tt.country, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q, %q) = %q, %v; want %q", tt.raw, tt.country, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Rule allows Limit hits per sliding Window. A zero Limit disables the rule.
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+type Limiter interface {
+	Allow(ctx context.Context, key string, rule Rule) (Decision, error)
+	// Prune drops hits older than maxAge; it must exceed the longest window in use.
+	Prune(ctx context.Context, maxAge time.Duration) error
+}
+
+// PostgresLimiter keeps a sliding log of hits in rate_limit_hits so limits
+// hold across replicas.
+type PostgresLimiter struct {
+	DB *sql.DB
+}
+
+func (l *PostgresLimiter) Allow(ctx context.Context, key string, rule Rule) (Decision, error) {
+	if rule.Limit <= 0 {
+		return Decision{Allowed: true}, nil
+	}
+
+	tx, err := l.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+		return Decision{}, err
+	}
+
+	now := time.Now()
+	if _, err = tx.ExecContext(ctx, `DELETE FROM rate_limit_hits WHERE key = $1 AND hit_at <= $2`, key, now.Add(-rule.Window)); err != nil {
+		return Decision{}, err
+	}
+
+	var (
+		count  int
+		oldest sql.NullTime
+	)
+	if err = tx.QueryRowContext(ctx, `SELECT COUNT(*), MIN(hit_at) FROM rate_limit_hits WHERE key = $1`, key).Scan(&count, &oldest); err != nil {
+		return Decision{}, err
+	}
+
+	decision := Decision{Allowed: count < rule.Limit}
+	if decision.Allowed {
+		if _, err = tx.ExecContext(ctx, `INSERT INTO rate_limit_hits (key, hit_at) VALUES ($1, $2)`, key, now); err != nil {
+			return Decision{}, err
+		}
+	} else if oldest.Valid {
+		decision.RetryAfter = oldest.Time.Add(rule.Window).Sub(now)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Decision{}, err
+	}
+	return decision, nil
+}
+
+func (l *PostgresLimiter) Prune(ctx context.Context, maxAge time.Duration) error {
+	_, err := l.DB.ExecContext(ctx, `DELETE FROM rate_limit_hits WHERE hit_at < $1`, time.Now().Add(-maxAge))
+	return err
+}
+
+// MemoryLimiter is the single-node variant of PostgresLimiter.
+type MemoryLimiter struct {
+	mu   sync.Mutex
+	hits map[string][]time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{hits: map[string][]time.Time{}}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, rule Rule) (Decision, error) {
+	if rule.Limit <= 0 {
+		return Decision{Allowed: true}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	hits := trim(l.hits[key], now.Add(-rule.Window))
+	if len(hits) >= rule.Limit {
+		l.hits[key] = hits
+		return Decision{RetryAfter: hits[0].Add(rule.Window).Sub(now)}, nil
+	}
+	l.hits[key] = append(hits, now)
+	return Decision{Allowed: true}, nil
+}
+
+func (l *MemoryLimiter) Prune(ctx context.Context, maxAge time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	for key, hits := range l.hits {
+		if hits = trim(hits, cutoff); len(hits) == 0 {
+			delete(l.hits, key)
+		} else {
+			l.hits[key] = hits
+		}
+	}
+	return nil
+}
+
+func trim(hits []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	return hits[i:]
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLimiter()
+	rule := Rule{Limit: 2, Window: time.Minute}
+
+	for i, want := range []bool{true, true, false} {
+		d, err := l.Allow(ctx, "a", rule)
+		if err != nil || d.Allowed != want {
+			t.Fatalf("hit %d: Allow = %+v, %v; want allowed=%v", i, d, err, want)
+		}
+		if !d.Allowed && (d.RetryAfter <= 0 || d.RetryAfter > rule.Window) {
+			t.Errorf("RetryAfter = %s, want within the window", d.RetryAfter)
+		}
+	}
+	if d, _ := l.Allow(ctx, "b", rule); !d.Allowed {
+		t.Error("keys must be limited independently")
+	}
+	for i := 0; i < 5; i++ {
+		if d, _ := l.Allow(ctx, "a", Rule{}); !d.Allowed {
+			t.Fatal("a zero limit must always allow")
+		}
+	}
+
+	short := Rule{Limit: 1, Window: 20 * time.Millisecond}
+	l.Allow(ctx, "c", short)
+	if d, _ := l.Allow(ctx, "c", short); d.Allowed {
+		t.Fatal("second hit inside the window must be denied")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if d, _ := l.Allow(ctx, "c", short); !d.Allowed {
+		t.Error("hit after the window must be allowed")
+	}
+
+	time.Sleep(time.Millisecond)
+	if err := l.Prune(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.hits) != 0 {
+		t.Errorf("Prune left %d keys", len(l.hits))
+	}
+}
@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type AuditLog struct {
+	ID        string
+	AdminID   string
+	Entity    string
+	EntityID  string
+	Action    string
+	Before    []byte
+	After     []byte
+	IP        sql.NullString
+	CreatedAt time.Time
+}
+
+// AuditLogFilter narrows List; zero values are ignored. AfterTime and
+// AfterID are the position of the last row of the previous page.
+type AuditLogFilter struct {
+	AdminID   string
+	Entity    string
+	EntityID  string
+	From      time.Time
+	To        time.Time
+	AfterTime time.Time
+	AfterID   string
+	Limit     int
+}
+
+type AuditLogRepository struct {
+	db *sql.DB
+}
+
+func NewAuditLogRepository(db *sql.DB) *AuditLogRepository {
+	return &AuditLogRepository{db: db}
+}
+
+// List returns matching entries newest first.
+func (r *AuditLogRepository) List(ctx context.Context, filter AuditLogFilter) ([]AuditLog, error) {
+	filters := []string{"1=1"}
+	args := []any{}
+	add := func(clause string, value any) {
+		args = append(args, value)
+		filters = append(filters, strings.ReplaceAll(clause, "?", "$"+itoa(len(args))))
+	}
+
+	if filter.AdminID != "" {
+		add("admin_id = ?", filter.AdminID)
+	}
+	if filter.Entity != "" {
+		add("entity = ?", filter.Entity)
+	}
+	if filter.EntityID != "" {
+		add("entity_id = ?", filter.EntityID)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("created_at < ?", filter.To)
+	}
+	if filter.AfterID != "" {
+		args = append(args, filter.AfterTime, filter.AfterID)
+		filters = append(filters, "(created_at, id) < ($"+itoa(len(args)-1)+", $"+itoa(len(args))+")")
+	}
+	args = append(args, filter.Limit)
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, admin_id, entity, entity_id, action, before_json, after_json, ip, created_at
+		FROM audit_logs
+		WHERE `+strings.Join(filters, " AND ")+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT $`+itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []AuditLog
+	for rows.Next() {
+		var item AuditLog
+		if err := rows.Scan(&item.ID, &item.AdminID, &item.Entity, &item.EntityID, &item.Action, &item.Before, &item.After, &item.IP, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, item)
+	}
+	return logs, rows.Err()
+}
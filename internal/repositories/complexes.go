@@ -14,16 +14,21 @@ type ResidentialComplex struct {
 	Status          string
 	Threshold       int
 	CurrentRequests int
+	ServiceDays     int
 }
 
 type ComplexRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewComplexRepository(db *sql.DB) *ComplexRepository {
 	return &ComplexRepository{db: db}
 }
 
+func (r *ComplexRepository) WithTx(tx *sql.Tx) *ComplexRepository {
+	return &ComplexRepository{db: tx}
+}
+
 func (r *ComplexRepository) List(ctx context.Context, search, status, city string, onlyActive bool, limit, offset int) ([]ResidentialComplex, error) {
 	filters := []string{"1=1"}
 	args := []any{}
@@ -48,7 +53,7 @@ func (r *ComplexRepository) List(ctx context.Context, search, status, city strin
 		filters = append(filters, "status = 'ACTIVE'")
 	}
 
-	query := `SELECT id, name, city, status, threshold_n, current_requests FROM residential_complexes WHERE ` + strings.Join(filters, " AND ") + ` ORDER BY name LIMIT $` + itoa(idx) + ` OFFSET $` + itoa(idx+1)
+	query := `SELECT id, name, city, status, threshold_n, current_requests, service_days FROM residential_complexes WHERE ` + strings.Join(filters, " AND ") + ` ORDER BY name LIMIT $` + itoa(idx) + ` OFFSET $` + itoa(idx+1)
 	args = append(args, limit, offset)
 
 	rows, err := r.db.QueryContext(ctx, query, args...)
@@ -60,7 +65,7 @@ func (r *ComplexRepository) List(ctx context.Context, search, status, city strin
 	var complexes []ResidentialComplex
 	for rows.Next() {
 		var item ResidentialComplex
-		if err := rows.Scan(&item.ID, &item.Name, &item.City, &item.Status, &item.Threshold, &item.CurrentRequests); err != nil {
+		if err := rows.Scan(&item.ID, &item.Name, &item.City, &item.Status, &item.Threshold, &item.CurrentRequests, &item.ServiceDays); err != nil {
 			return nil, err
 		}
 		complexes = append(complexes, item)
@@ -71,10 +76,10 @@ func (r *ComplexRepository) List(ctx context.Context, search, status, city strin
 func (r *ComplexRepository) Get(ctx context.Context, id string) (ResidentialComplex, error) {
 	var item ResidentialComplex
 	err := r.db.QueryRowContext(ctx, `
-		SELECT id, name, city, status, threshold_n, current_requests
+		SELECT id, name, city, status, threshold_n, current_requests, service_days
 		FROM residential_complexes
 		WHERE id = $1
-	`, id).Scan(&item.ID, &item.Name, &item.City, &item.Status, &item.Threshold, &item.CurrentRequests)
+	`, id).Scan(&item.ID, &item.Name, &item.City, &item.Status, &item.Threshold, &item.CurrentRequests, &item.ServiceDays)
 	return item, err
 }
 
@@ -87,12 +92,27 @@ func (r *ComplexRepository) UpdateStatusAndRequests(ctx context.Context, id, sta
 
 func (r *ComplexRepository) Create(ctx context.Context, complex ResidentialComplex) error {
 	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO residential_complexes (id, name, city, status, threshold_n, current_requests)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, complex.ID, complex.Name, complex.City, complex.Status, complex.Threshold, complex.CurrentRequests)
+		INSERT INTO residential_complexes (id, name, city, status, threshold_n, current_requests, service_days)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, complex.ID, complex.Name, complex.City, complex.Status, complex.Threshold, complex.CurrentRequests, complex.ServiceDays)
 	return err
 }
 
+func (r *ComplexRepository) UpdateServiceDays(ctx context.Context, id string, serviceDays int) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE residential_complexes SET service_days = $2 WHERE id = $1`, id, serviceDays)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func itoa(value int) string {
 	return strconv.Itoa(value)
 }
@@ -0,0 +1,73 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RouteStop struct {
+	PickupID       string
+	SubscriptionID string
+	ComplexID      string
+	ComplexName    string
+	AddressJSON    []byte
+	TimeWindow     sql.NullString
+	Instructions   sql.NullString
+	Status         string
+	Reason         sql.NullString
+}
+
+type CourierRepository struct {
+	db *sql.DB
+}
+
+func NewCourierRepository(db *sql.DB) *CourierRepository {
+	return &CourierRepository{db: db}
+}
+
+func (r *CourierRepository) ListComplexIDs(ctx context.Context, courierID string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT complex_id FROM courier_complexes WHERE courier_id = $1 ORDER BY complex_id
+	`, courierID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *CourierRepository) ListRoute(ctx context.Context, courierID string, date time.Time) ([]RouteStop, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT p.id, p.subscription_id, c.id, c.name, s.address_json, COALESCE(p.time_window, s.time_window), s.instructions, p.status, p.reason
+		FROM pickup_logs p
+		JOIN subscriptions s ON s.id = p.subscription_id
+		JOIN residential_complexes c ON c.id = s.complex_id
+		JOIN courier_complexes cc ON cc.complex_id = c.id AND cc.courier_id = $1
+		WHERE p.pickup_date = $2
+		ORDER BY c.name, c.id
+	`, courierID, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stops []RouteStop
+	for rows.Next() {
+		var stop RouteStop
+		if err := rows.Scan(&stop.PickupID, &stop.SubscriptionID, &stop.ComplexID, &stop.ComplexName, &stop.AddressJSON, &stop.TimeWindow, &stop.Instructions, &stop.Status, &stop.Reason); err != nil {
+			return nil, err
+		}
+		stops = append(stops, stop)
+	}
+	return stops, rows.Err()
+}
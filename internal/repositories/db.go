@@ -0,0 +1,14 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbtx is implemented by both *sql.DB and *sql.Tx, so a repository returned
+// by WithTx runs its queries inside the caller's transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
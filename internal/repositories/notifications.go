@@ -0,0 +1,49 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type Notification struct {
+	ID         string
+	UserID     string
+	Kind       string
+	Entity     string
+	EntityID   string
+	PayloadRaw []byte
+	CreatedAt  time.Time
+}
+
+type NotificationRepository struct {
+	db *sql.DB
+}
+
+func NewNotificationRepository(db *sql.DB) *NotificationRepository {
+	return &NotificationRepository{db: db}
+}
+
+func (r *NotificationRepository) ListByUser(ctx context.Context, userID string, limit int) ([]Notification, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, kind, entity, entity_id, payload_json, created_at
+		FROM notifications
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Notification
+	for rows.Next() {
+		var item Notification
+		if err := rows.Scan(&item.ID, &item.UserID, &item.Kind, &item.Entity, &item.EntityID, &item.PayloadRaw, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
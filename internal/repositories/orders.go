@@ -25,25 +25,21 @@ type OrderItem struct {
 }
 
 type OrderRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewOrderRepository(db *sql.DB) *OrderRepository {
 	return &OrderRepository{db: db}
 }
 
-func (r *OrderRepository) Create(ctx context.Context, order Order, items []OrderItem) error {
-	tx, err := r.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
-		}
-	}()
+func (r *OrderRepository) WithTx(tx *sql.Tx) *OrderRepository {
+	return &OrderRepository{db: tx}
+}
 
-	_, err = tx.ExecContext(ctx, `
+// Create inserts the order with its items; run it through WithTx so both land
+// atomically.
+func (r *OrderRepository) Create(ctx context.Context, order Order, items []OrderItem) error {
+	_, err := r.db.ExecContext(ctx, `
 		INSERT INTO orders (id, user_id, status, address_json, comment, total_cents)
 		VALUES ($1, $2, $3, $4, $5, $6)
 	`, order.ID, order.UserID, order.Status, order.AddressRaw, order.Comment, order.TotalCents)
@@ -52,7 +48,7 @@ func (r *OrderRepository) Create(ctx context.Context, order Order, items []Order
 	}
 
 	for _, item := range items {
-		_, err = tx.ExecContext(ctx, `
+		_, err = r.db.ExecContext(ctx, `
 			INSERT INTO order_items (id, order_id, product_id, quantity, price_cents)
 			VALUES ($1, $2, $3, $4, $5)
 		`, item.ID, item.OrderID, item.ProductID, item.Quantity, item.PriceCents)
@@ -60,8 +56,7 @@ func (r *OrderRepository) Create(ctx context.Context, order Order, items []Order
 			return err
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
 
 func (r *OrderRepository) ListByUser(ctx context.Context, userID string) ([]Order, error) {
@@ -13,6 +13,7 @@ type OTPCode struct {
 	ExpiresAt    time.Time
 	Attempts     int
 	BlockedUntil sql.NullTime
+	UsedAt       sql.NullTime
 	CreatedAt    time.Time
 }
 
@@ -35,25 +36,58 @@ func (r *OTPRepository) Create(ctx context.Context, code OTPCode) error {
 func (r *OTPRepository) LatestByPhone(ctx context.Context, phone string) (OTPCode, error) {
 	var code OTPCode
 	err := r.db.QueryRowContext(ctx, `
-		SELECT id, phone, code_hash, expires_at, attempts, blocked_until, created_at
+		SELECT id, phone, code_hash, expires_at, attempts, blocked_until, used_at, created_at
 		FROM otp_codes
 		WHERE phone = $1
 		ORDER BY created_at DESC
 		LIMIT 1
-	`, phone).Scan(&code.ID, &code.Phone, &code.CodeHash, &code.ExpiresAt, &code.Attempts, &code.BlockedUntil, &code.CreatedAt)
+	`, phone).Scan(&code.ID, &code.Phone, &code.CodeHash, &code.ExpiresAt, &code.Attempts, &code.BlockedUntil, &code.UsedAt, &code.CreatedAt)
 	return code, err
 }
 
-func (r *OTPRepository) IncrementAttempts(ctx context.Context, id string, attempts int) error {
+// InvalidateOutstanding marks every unused code for the phone as used,
+// except keepID.
+func (r *OTPRepository) InvalidateOutstanding(ctx context.Context, phone, keepID string, at time.Time) error {
 	_, err := r.db.ExecContext(ctx, `
-		UPDATE otp_codes SET attempts = $2 WHERE id = $1
-	`, id, attempts)
+		UPDATE otp_codes SET used_at = $3 WHERE phone = $1 AND id <> $2 AND used_at IS NULL
+	`, phone, keepID, at)
 	return err
 }
 
+// Consume marks the code used. It reports false if it was already used, so a
+// code cannot be redeemed twice by concurrent requests.
+func (r *OTPRepository) Consume(ctx context.Context, id string, at time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE otp_codes SET used_at = $2 WHERE id = $1 AND used_at IS NULL
+	`, id, at)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// IncrementAttempts bumps the failed attempt counter in the database so
+// concurrent wrong guesses cannot overwrite each other, and returns the new value.
+func (r *OTPRepository) IncrementAttempts(ctx context.Context, id string) (int, error) {
+	var attempts int
+	err := r.db.QueryRowContext(ctx, `
+		UPDATE otp_codes SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts
+	`, id).Scan(&attempts)
+	return attempts, err
+}
+
 func (r *OTPRepository) Block(ctx context.Context, id string, until time.Time) error {
 	_, err := r.db.ExecContext(ctx, `
 		UPDATE otp_codes SET blocked_until = $2 WHERE id = $1
 	`, id, until)
 	return err
 }
+
+func (r *OTPRepository) Delete(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM otp_codes WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type PaymentEvent struct {
+	ID             string
+	Provider       string
+	EventID        sql.NullString
+	PaymentID      sql.NullString
+	HeadersRaw     []byte
+	Body           []byte
+	SignatureValid bool
+	Status         sql.NullString
+	Outcome        string
+	Error          sql.NullString
+	Duplicates     int
+	ReceivedAt     time.Time
+	ProcessedAt    sql.NullTime
+}
+
+type PaymentEventFilter struct {
+	Provider  string
+	PaymentID string
+	Outcome   string
+	Limit     int
+}
+
+type PaymentEventRepository struct {
+	db *sql.DB
+}
+
+func NewPaymentEventRepository(db *sql.DB) *PaymentEventRepository {
+	return &PaymentEventRepository{db: db}
+}
+
+// Create stores the event and reports false, without storing anything, when
+// the provider already sent an event with the same id.
+func (r *PaymentEventRepository) Create(ctx context.Context, event PaymentEvent) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO payment_events (id, provider, event_id, payment_id, headers_json, body, signature_valid, status, outcome, error, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (provider, event_id) DO NOTHING
+	`, event.ID, event.Provider, event.EventID, event.PaymentID, event.HeadersRaw, event.Body, event.SignatureValid, event.Status, event.Outcome, event.Error, event.ReceivedAt)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+const paymentEventColumns = `id, provider, event_id, payment_id, headers_json, body, signature_valid, status, outcome, error, duplicates, received_at, processed_at`
+
+func scanPaymentEvent(row interface{ Scan(...any) error }) (PaymentEvent, error) {
+	var event PaymentEvent
+	err := row.Scan(&event.ID, &event.Provider, &event.EventID, &event.PaymentID, &event.HeadersRaw, &event.Body, &event.SignatureValid, &event.Status, &event.Outcome, &event.Error, &event.Duplicates, &event.ReceivedAt, &event.ProcessedAt)
+	return event, err
+}
+
+func (r *PaymentEventRepository) Get(ctx context.Context, id string) (PaymentEvent, error) {
+	return scanPaymentEvent(r.db.QueryRowContext(ctx, `SELECT `+paymentEventColumns+` FROM payment_events WHERE id = $1`, id))
+}
+
+// MarkDuplicate counts a redelivery and returns the stored event.
+func (r *PaymentEventRepository) MarkDuplicate(ctx context.Context, provider, eventID string) (PaymentEvent, error) {
+	return scanPaymentEvent(r.db.QueryRowContext(ctx, `
+		UPDATE payment_events SET duplicates = duplicates + 1
+		WHERE provider = $1 AND event_id = $2
+		RETURNING `+paymentEventColumns, provider, eventID))
+}
+
+func (r *PaymentEventRepository) Finish(ctx context.Context, id string, paymentID sql.NullString, outcome string, processErr sql.NullString, processedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE payment_events SET payment_id = COALESCE($2, payment_id), outcome = $3, error = $4, processed_at = $5 WHERE id = $1
+	`, id, paymentID, outcome, processErr, processedAt)
+	return err
+}
+
+// List returns events newest first.
+func (r *PaymentEventRepository) List(ctx context.Context, filter PaymentEventFilter) ([]PaymentEvent, error) {
+	filters := []string{"1=1"}
+	args := []any{}
+	if filter.Provider != "" {
+		args = append(args, filter.Provider)
+		filters = append(filters, "provider = $"+itoa(len(args)))
+	}
+	if filter.PaymentID != "" {
+		args = append(args, filter.PaymentID)
+		filters = append(filters, "payment_id = $"+itoa(len(args)))
+	}
+	if filter.Outcome != "" {
+		args = append(args, filter.Outcome)
+		filters = append(filters, "outcome = $"+itoa(len(args)))
+	}
+	args = append(args, filter.Limit)
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+paymentEventColumns+` FROM payment_events WHERE `+strings.Join(filters, " AND ")+` ORDER BY received_at DESC, id DESC LIMIT $`+itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []PaymentEvent
+	for rows.Next() {
+		event, err := scanPaymentEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type PaymentRefund struct {
+	ID               string
+	PaymentID        string
+	AmountCents      int
+	Reason           sql.NullString
+	ItemsRaw         []byte
+	CancelSub        bool
+	ProviderRefundID sql.NullString
+	Status           string
+	AdminID          sql.NullString
+	EventID          sql.NullString
+	CreatedAt        time.Time
+}
+
+type PaymentRefundRepository struct {
+	db dbtx
+}
+
+func NewPaymentRefundRepository(db *sql.DB) *PaymentRefundRepository {
+	return &PaymentRefundRepository{db: db}
+}
+
+func (r *PaymentRefundRepository) WithTx(tx *sql.Tx) *PaymentRefundRepository {
+	return &PaymentRefundRepository{db: tx}
+}
+
+func (r *PaymentRefundRepository) Create(ctx context.Context, refund PaymentRefund) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO payment_refunds (id, payment_id, amount_cents, reason, items_json, cancel_subscription, provider_refund_id, status, admin_id, event_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, refund.ID, refund.PaymentID, refund.AmountCents, refund.Reason, refund.ItemsRaw, refund.CancelSub, refund.ProviderRefundID, refund.Status, refund.AdminID, refund.EventID, refund.CreatedAt)
+	return err
+}
+
+// FindByStatus returns the payment's refund in status; there is at most one
+// PENDING refund per payment.
+func (r *PaymentRefundRepository) FindByStatus(ctx context.Context, paymentID, status string) (PaymentRefund, error) {
+	var item PaymentRefund
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, payment_id, amount_cents, reason, items_json, cancel_subscription, provider_refund_id, status, admin_id, event_id, created_at
+		FROM payment_refunds
+		WHERE payment_id = $1 AND status = $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, paymentID, status).Scan(&item.ID, &item.PaymentID, &item.AmountCents, &item.Reason, &item.ItemsRaw, &item.CancelSub, &item.ProviderRefundID, &item.Status, &item.AdminID, &item.EventID, &item.CreatedAt)
+	return item, err
+}
+
+// Finish moves a refund out of from; false means it has already left it.
+func (r *PaymentRefundRepository) Finish(ctx context.Context, id, from, status string, providerRefundID sql.NullString) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE payment_refunds SET status = $3, provider_refund_id = COALESCE($4, provider_refund_id)
+		WHERE id = $1 AND status = $2
+	`, id, from, status, providerRefundID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *PaymentRefundRepository) ListByPayment(ctx context.Context, paymentID string) ([]PaymentRefund, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, payment_id, amount_cents, reason, items_json, cancel_subscription, provider_refund_id, status, admin_id, event_id, created_at
+		FROM payment_refunds
+		WHERE payment_id = $1
+		ORDER BY created_at
+	`, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []PaymentRefund
+	for rows.Next() {
+		var item PaymentRefund
+		if err := rows.Scan(&item.ID, &item.PaymentID, &item.AmountCents, &item.Reason, &item.ItemsRaw, &item.CancelSub, &item.ProviderRefundID, &item.Status, &item.AdminID, &item.EventID, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, item)
+	}
+	return refunds, rows.Err()
+}
@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
 type Payment struct {
@@ -13,7 +14,13 @@ type Payment struct {
 	ProviderPayment sql.NullString
 	Status          string
 	AmountCents     int
+	RefundedCents   int
 	PayloadRaw      []byte
+	PeriodStart     sql.NullTime
+	PeriodEnd       sql.NullTime
+	RefundRequired  sql.NullString
+	CheckoutAt      sql.NullTime
+	CreatedAt       time.Time
 }
 
 type PaymentRepository struct {
@@ -26,9 +33,9 @@ func NewPaymentRepository(db *sql.DB) *PaymentRepository {
 
 func (r *PaymentRepository) Create(ctx context.Context, payment Payment) error {
 	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO payments (id, type, entity_id, provider, provider_payment_id, status, amount_cents, payload_json)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`, payment.ID, payment.Type, payment.EntityID, payment.Provider, payment.ProviderPayment, payment.Status, payment.AmountCents, payment.PayloadRaw)
+		INSERT INTO payments (id, type, entity_id, provider, provider_payment_id, status, amount_cents, payload_json, period_start, period_end)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, payment.ID, payment.Type, payment.EntityID, payment.Provider, payment.ProviderPayment, payment.Status, payment.AmountCents, payment.PayloadRaw, payment.PeriodStart, payment.PeriodEnd)
 	return err
 }
 
@@ -39,12 +46,62 @@ func (r *PaymentRepository) UpdateStatus(ctx context.Context, id, status string,
 	return err
 }
 
-func (r *PaymentRepository) FindByProviderID(ctx context.Context, provider, providerPaymentID string) (Payment, error) {
+const paymentColumns = `id, type, entity_id, provider, provider_payment_id, status, amount_cents, refunded_cents, payload_json, period_start, period_end, refund_required, checkout_at, created_at`
+
+func scanPayment(row interface{ Scan(...any) error }) (Payment, error) {
 	var payment Payment
-	err := r.db.QueryRowContext(ctx, `
-		SELECT id, type, entity_id, provider, provider_payment_id, status, amount_cents, payload_json
+	err := row.Scan(&payment.ID, &payment.Type, &payment.EntityID, &payment.Provider, &payment.ProviderPayment, &payment.Status, &payment.AmountCents, &payment.RefundedCents, &payment.PayloadRaw, &payment.PeriodStart, &payment.PeriodEnd, &payment.RefundRequired, &payment.CheckoutAt, &payment.CreatedAt)
+	return payment, err
+}
+
+func (r *PaymentRepository) Get(ctx context.Context, id string) (Payment, error) {
+	return scanPayment(r.db.QueryRowContext(ctx, `SELECT `+paymentColumns+` FROM payments WHERE id = $1`, id))
+}
+
+func (r *PaymentRepository) FindByProviderID(ctx context.Context, provider, providerPaymentID string) (Payment, error) {
+	return scanPayment(r.db.QueryRowContext(ctx, `
+		SELECT `+paymentColumns+`
 		FROM payments
 		WHERE provider = $1 AND provider_payment_id = $2
-	`, provider, providerPaymentID).Scan(&payment.ID, &payment.Type, &payment.EntityID, &payment.Provider, &payment.ProviderPayment, &payment.Status, &payment.AmountCents, &payment.PayloadRaw)
-	return payment, err
+	`, provider, providerPaymentID))
+}
+
+// ListByProvider returns the provider's payments created in [from, to).
+func (r *PaymentRepository) ListByProvider(ctx context.Context, provider string, from, to time.Time) ([]Payment, error) {
+	return r.list(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE provider = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at, id
+	`, provider, from, to)
+}
+
+// ListRefundRequired returns paid payments that could not be applied and
+// wait for an admin refund, oldest first.
+func (r *PaymentRepository) ListRefundRequired(ctx context.Context, limit int) ([]Payment, error) {
+	return r.list(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE refund_required IS NOT NULL
+		ORDER BY created_at, id
+		LIMIT $1
+	`, limit)
+}
+
+func (r *PaymentRepository) list(ctx context.Context, query string, args ...any) ([]Payment, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, payment)
+	}
+	return items, rows.Err()
 }
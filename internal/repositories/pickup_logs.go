@@ -13,6 +13,10 @@ type PickupLog struct {
 	Status         string
 	Comment        sql.NullString
 	Reason         sql.NullString
+	TimeWindow     sql.NullString
+	PhotoRef       sql.NullString
+	CourierID      sql.NullString
+	CompletedAt    sql.NullTime
 }
 
 type PickupLogRepository struct {
@@ -25,7 +29,7 @@ func NewPickupLogRepository(db *sql.DB) *PickupLogRepository {
 
 func (r *PickupLogRepository) ListBySubscription(ctx context.Context, subscriptionID string) ([]PickupLog, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, subscription_id, pickup_date, status, comment, reason
+		SELECT id, subscription_id, pickup_date, status, comment, reason, time_window
 		FROM pickup_logs
 		WHERE subscription_id = $1
 		ORDER BY pickup_date DESC
@@ -38,7 +42,7 @@ func (r *PickupLogRepository) ListBySubscription(ctx context.Context, subscripti
 	var logs []PickupLog
 	for rows.Next() {
 		var log PickupLog
-		if err := rows.Scan(&log.ID, &log.SubscriptionID, &log.PickupDate, &log.Status, &log.Comment, &log.Reason); err != nil {
+		if err := rows.Scan(&log.ID, &log.SubscriptionID, &log.PickupDate, &log.Status, &log.Comment, &log.Reason, &log.TimeWindow); err != nil {
 			return nil, err
 		}
 		logs = append(logs, log)
@@ -48,15 +52,43 @@ func (r *PickupLogRepository) ListBySubscription(ctx context.Context, subscripti
 
 func (r *PickupLogRepository) Create(ctx context.Context, log PickupLog) error {
 	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO pickup_logs (id, subscription_id, pickup_date, status, comment, reason)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, log.ID, log.SubscriptionID, log.PickupDate, log.Status, log.Comment, log.Reason)
+		INSERT INTO pickup_logs (id, subscription_id, pickup_date, status, comment, reason, time_window)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, log.ID, log.SubscriptionID, log.PickupDate, log.Status, log.Comment, log.Reason, log.TimeWindow)
 	return err
 }
 
-func (r *PickupLogRepository) Update(ctx context.Context, log PickupLog) error {
-	_, err := r.db.ExecContext(ctx, `
-		UPDATE pickup_logs SET status = $2, comment = $3, reason = $4 WHERE id = $1
-	`, log.ID, log.Status, log.Comment, log.Reason)
-	return err
+type PickupLogHistory struct {
+	ID          string
+	PickupLogID string
+	FromStatus  sql.NullString
+	ToStatus    string
+	Reason      sql.NullString
+	Comment     sql.NullString
+	ActorID     sql.NullString
+	Source      string
+	CreatedAt   time.Time
+}
+
+func (r *PickupLogRepository) ListHistory(ctx context.Context, pickupLogID string) ([]PickupLogHistory, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, pickup_log_id, from_status, to_status, reason, comment, actor_id, source, created_at
+		FROM pickup_log_history
+		WHERE pickup_log_id = $1
+		ORDER BY created_at
+	`, pickupLogID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []PickupLogHistory{}
+	for rows.Next() {
+		var item PickupLogHistory
+		if err := rows.Scan(&item.ID, &item.PickupLogID, &item.FromStatus, &item.ToStatus, &item.Reason, &item.Comment, &item.ActorID, &item.Source, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
 }
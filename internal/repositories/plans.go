@@ -3,29 +3,37 @@ package repositories
 import (
 	"context"
 	"database/sql"
+
+	"nesta/internal/frequency"
 )
 
 type Plan struct {
-	ID          string
-	Name        string
-	PriceCents  int
-	Frequency   string
-	BagsPerDay  int
-	Description sql.NullString
-	IsActive    bool
+	ID              string
+	Name            string
+	PriceCents      int
+	Frequency       string
+	BagsPerDay      int
+	Description     sql.NullString
+	IsActive        bool
+	MaxPauseDays    sql.NullInt64
+	PickupFrequency string
 }
 
 type PlanRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewPlanRepository(db *sql.DB) *PlanRepository {
 	return &PlanRepository{db: db}
 }
 
+func (r *PlanRepository) WithTx(tx *sql.Tx) *PlanRepository {
+	return &PlanRepository{db: tx}
+}
+
 func (r *PlanRepository) ListActive(ctx context.Context) ([]Plan, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, name, price_cents, frequency, bags_per_day, description, is_active
+		SELECT id, name, price_cents, frequency, bags_per_day, description, is_active, max_pause_days, pickup_frequency
 		FROM plans
 		WHERE is_active = TRUE
 		ORDER BY price_cents
@@ -38,7 +46,7 @@ func (r *PlanRepository) ListActive(ctx context.Context) ([]Plan, error) {
 	var plans []Plan
 	for rows.Next() {
 		var plan Plan
-		if err := rows.Scan(&plan.ID, &plan.Name, &plan.PriceCents, &plan.Frequency, &plan.BagsPerDay, &plan.Description, &plan.IsActive); err != nil {
+		if err := rows.Scan(&plan.ID, &plan.Name, &plan.PriceCents, &plan.Frequency, &plan.BagsPerDay, &plan.Description, &plan.IsActive, &plan.MaxPauseDays, &plan.PickupFrequency); err != nil {
 			return nil, err
 		}
 		plans = append(plans, plan)
@@ -47,28 +55,56 @@ func (r *PlanRepository) ListActive(ctx context.Context) ([]Plan, error) {
 }
 
 func (r *PlanRepository) Create(ctx context.Context, plan Plan) error {
-	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO plans (id, name, price_cents, frequency, bags_per_day, description, is_active)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, plan.ID, plan.Name, plan.PriceCents, plan.Frequency, plan.BagsPerDay, plan.Description, plan.IsActive)
+	freq, err := frequency.Parse(plan.Frequency)
+	if err != nil {
+		return err
+	}
+	plan.Frequency = freq.String()
+	if plan.PickupFrequency == "" {
+		plan.PickupFrequency = "days:1"
+	}
+	pickupFreq, err := frequency.Parse(plan.PickupFrequency)
+	if err != nil {
+		return err
+	}
+	plan.PickupFrequency = pickupFreq.String()
+
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO plans (id, name, price_cents, frequency, bags_per_day, description, is_active, max_pause_days, pickup_frequency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, plan.ID, plan.Name, plan.PriceCents, plan.Frequency, plan.BagsPerDay, plan.Description, plan.IsActive, plan.MaxPauseDays, plan.PickupFrequency)
 	return err
 }
 
 func (r *PlanRepository) Get(ctx context.Context, id string) (Plan, error) {
 	var plan Plan
 	err := r.db.QueryRowContext(ctx, `
-		SELECT id, name, price_cents, frequency, bags_per_day, description, is_active
+		SELECT id, name, price_cents, frequency, bags_per_day, description, is_active, max_pause_days, pickup_frequency
 		FROM plans
 		WHERE id = $1
-	`, id).Scan(&plan.ID, &plan.Name, &plan.PriceCents, &plan.Frequency, &plan.BagsPerDay, &plan.Description, &plan.IsActive)
+	`, id).Scan(&plan.ID, &plan.Name, &plan.PriceCents, &plan.Frequency, &plan.BagsPerDay, &plan.Description, &plan.IsActive, &plan.MaxPauseDays, &plan.PickupFrequency)
 	return plan, err
 }
 
 func (r *PlanRepository) Update(ctx context.Context, plan Plan) error {
-	_, err := r.db.ExecContext(ctx, `
+	freq, err := frequency.Parse(plan.Frequency)
+	if err != nil {
+		return err
+	}
+	plan.Frequency = freq.String()
+	if plan.PickupFrequency == "" {
+		plan.PickupFrequency = "days:1"
+	}
+	pickupFreq, err := frequency.Parse(plan.PickupFrequency)
+	if err != nil {
+		return err
+	}
+	plan.PickupFrequency = pickupFreq.String()
+
+	_, err = r.db.ExecContext(ctx, `
 		UPDATE plans
-		SET name = $2, price_cents = $3, frequency = $4, bags_per_day = $5, description = $6, is_active = $7
+		SET name = $2, price_cents = $3, frequency = $4, bags_per_day = $5, description = $6, is_active = $7, max_pause_days = $8, pickup_frequency = $9
 		WHERE id = $1
-	`, plan.ID, plan.Name, plan.PriceCents, plan.Frequency, plan.BagsPerDay, plan.Description, plan.IsActive)
+	`, plan.ID, plan.Name, plan.PriceCents, plan.Frequency, plan.BagsPerDay, plan.Description, plan.IsActive, plan.MaxPauseDays, plan.PickupFrequency)
 	return err
 }
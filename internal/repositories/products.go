@@ -17,13 +17,17 @@ type Product struct {
 }
 
 type ProductRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewProductRepository(db *sql.DB) *ProductRepository {
 	return &ProductRepository{db: db}
 }
 
+func (r *ProductRepository) WithTx(tx *sql.Tx) *ProductRepository {
+	return &ProductRepository{db: tx}
+}
+
 func (r *ProductRepository) List(ctx context.Context, category, search string, inStock bool, limit, offset int) ([]Product, error) {
 	filters := []string{"is_active = TRUE"}
 	args := []any{}
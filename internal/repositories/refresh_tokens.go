@@ -7,11 +7,29 @@ import (
 )
 
 type RefreshToken struct {
-	ID        string
-	UserID    string
-	Token     string
-	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	ID         string
+	UserID     string
+	TokenHash  string
+	FamilyID   string
+	ReplacedBy sql.NullString
+	DeviceName sql.NullString
+	UserAgent  sql.NullString
+	IP         sql.NullString
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	LastUsedAt time.Time
+}
+
+// Session is a refresh token family as shown to the user: one login on one device.
+type Session struct {
+	ID         string
+	UserID     string
+	DeviceName sql.NullString
+	UserAgent  sql.NullString
+	IP         sql.NullString
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
 }
 
 type RefreshTokenRepository struct {
@@ -24,25 +42,91 @@ func NewRefreshTokenRepository(db *sql.DB) *RefreshTokenRepository {
 
 func (r *RefreshTokenRepository) Create(ctx context.Context, token RefreshToken) error {
 	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO refresh_tokens (id, user_id, token, expires_at, revoked_at)
-		VALUES ($1, $2, $3, $4, $5)
-	`, token.ID, token.UserID, token.Token, token.ExpiresAt, token.RevokedAt)
+		INSERT INTO refresh_tokens (id, user_id, token_hash, family_id, device_name, user_agent, ip, expires_at, revoked_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, token.ID, token.UserID, token.TokenHash, token.FamilyID, token.DeviceName, token.UserAgent, token.IP, token.ExpiresAt, token.RevokedAt, token.LastUsedAt)
 	return err
 }
 
-func (r *RefreshTokenRepository) FindByToken(ctx context.Context, token string) (RefreshToken, error) {
+func (r *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (RefreshToken, error) {
 	var row RefreshToken
 	err := r.db.QueryRowContext(ctx, `
-		SELECT id, user_id, token, expires_at, revoked_at
+		SELECT id, user_id, token_hash, family_id, replaced_by, device_name, user_agent, ip, expires_at, revoked_at, last_used_at
 		FROM refresh_tokens
-		WHERE token = $1
-	`, token).Scan(&row.ID, &row.UserID, &row.Token, &row.ExpiresAt, &row.RevokedAt)
+		WHERE token_hash = $1
+	`, hash).Scan(&row.ID, &row.UserID, &row.TokenHash, &row.FamilyID, &row.ReplacedBy, &row.DeviceName, &row.UserAgent, &row.IP, &row.ExpiresAt, &row.RevokedAt, &row.LastUsedAt)
 	return row, err
 }
 
-func (r *RefreshTokenRepository) Revoke(ctx context.Context, token string, revokedAt time.Time) error {
+// RevokeFamily revokes every still active token rotated from the same login.
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
 	_, err := r.db.ExecContext(ctx, `
-		UPDATE refresh_tokens SET revoked_at = $2 WHERE token = $1
-	`, token, revokedAt)
+		UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID, revokedAt)
 	return err
 }
+
+// ListSessions returns the user's active sessions, most recently used first.
+// Rotation keeps exactly one unrevoked token per live family.
+func (r *RefreshTokenRepository) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT t.family_id, t.user_id, t.device_name, t.user_agent, t.ip,
+			(SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = t.family_id),
+			t.last_used_at, t.expires_at
+		FROM refresh_tokens t
+		WHERE t.user_id = $1 AND t.revoked_at IS NULL AND t.expires_at > NOW()
+		ORDER BY t.last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var item Session
+		if err := rows.Scan(&item.ID, &item.UserID, &item.DeviceName, &item.UserAgent, &item.IP, &item.CreatedAt, &item.LastUsedAt, &item.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, item)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes one of the user's sessions. It returns sql.ErrNoRows
+// when the user has no such active session.
+func (r *RefreshTokenRepository) RevokeSession(ctx context.Context, userID, sessionID string, revokedAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = $3
+		WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+	`, userID, sessionID, revokedAt)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) RevokeUser(ctx context.Context, userID string, revokedAt time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, revokedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *RefreshTokenRepository) SessionActive(ctx context.Context, sessionID string) (bool, error) {
+	var active bool
+	err := r.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > NOW())
+	`, sessionID).Scan(&active)
+	return active, err
+}
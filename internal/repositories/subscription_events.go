@@ -0,0 +1,49 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type SubscriptionEvent struct {
+	ID             string
+	SubscriptionID string
+	Action         string
+	FromStatus     string
+	ToStatus       string
+	ActorID        sql.NullString
+	Reason         sql.NullString
+	CreatedAt      time.Time
+}
+
+type SubscriptionEventRepository struct {
+	db *sql.DB
+}
+
+func NewSubscriptionEventRepository(db *sql.DB) *SubscriptionEventRepository {
+	return &SubscriptionEventRepository{db: db}
+}
+
+func (r *SubscriptionEventRepository) ListBySubscription(ctx context.Context, subscriptionID string) ([]SubscriptionEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, subscription_id, action, from_status, to_status, actor_id, reason, created_at
+		FROM subscription_events
+		WHERE subscription_id = $1
+		ORDER BY created_at
+	`, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SubscriptionEvent
+	for rows.Next() {
+		var event SubscriptionEvent
+		if err := rows.Scan(&event.ID, &event.SubscriptionID, &event.Action, &event.FromStatus, &event.ToStatus, &event.ActorID, &event.Reason, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type SubscriptionPause struct {
+	ID             string
+	SubscriptionID string
+	StartsOn       time.Time
+	EndsOn         sql.NullTime
+	Status         string
+	CreatedAt      time.Time
+}
+
+type SubscriptionPauseRepository struct {
+	db *sql.DB
+}
+
+func NewSubscriptionPauseRepository(db *sql.DB) *SubscriptionPauseRepository {
+	return &SubscriptionPauseRepository{db: db}
+}
+
+func (r *SubscriptionPauseRepository) ListBySubscription(ctx context.Context, subscriptionID string) ([]SubscriptionPause, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, subscription_id, starts_on, ends_on, status, created_at
+		FROM subscription_pauses
+		WHERE subscription_id = $1
+		ORDER BY starts_on DESC
+	`, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pauses []SubscriptionPause
+	for rows.Next() {
+		var pause SubscriptionPause
+		if err := rows.Scan(&pause.ID, &pause.SubscriptionID, &pause.StartsOn, &pause.EndsOn, &pause.Status, &pause.CreatedAt); err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, pause)
+	}
+	return pauses, rows.Err()
+}
@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type PlanChange struct {
+	ID             string
+	SubscriptionID string
+	FromPlanID     string
+	ToPlanID       string
+	Kind           string
+	AmountCents    int
+	Status         string
+	EffectiveAt    time.Time
+	AppliedAt      sql.NullTime
+	CreatedAt      time.Time
+}
+
+type PlanChangeRepository struct {
+	db *sql.DB
+}
+
+func NewPlanChangeRepository(db *sql.DB) *PlanChangeRepository {
+	return &PlanChangeRepository{db: db}
+}
+
+func (r *PlanChangeRepository) ListBySubscription(ctx context.Context, subscriptionID string) ([]PlanChange, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, subscription_id, from_plan_id, to_plan_id, kind, amount_cents, status, effective_at, applied_at, created_at
+		FROM subscription_plan_changes
+		WHERE subscription_id = $1
+		ORDER BY created_at DESC
+	`, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []PlanChange
+	for rows.Next() {
+		var change PlanChange
+		if err := rows.Scan(&change.ID, &change.SubscriptionID, &change.FromPlanID, &change.ToPlanID, &change.Kind, &change.AmountCents, &change.Status, &change.EffectiveAt, &change.AppliedAt, &change.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
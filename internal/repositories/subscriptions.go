@@ -17,6 +17,7 @@ type Subscription struct {
 	Instructions       sql.NullString
 	CurrentPeriodStart sql.NullTime
 	CurrentPeriodEnd   sql.NullTime
+	CancelAt           sql.NullTime
 	CreatedAt          time.Time
 }
 
@@ -40,7 +41,7 @@ func (r *SubscriptionRepository) Create(ctx context.Context, sub Subscription) e
 
 func (r *SubscriptionRepository) ListByUser(ctx context.Context, userID string) ([]Subscription, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, user_id, complex_id, plan_id, status, address_json, time_window, instructions, current_period_start, current_period_end, created_at
+		SELECT id, user_id, complex_id, plan_id, status, address_json, time_window, instructions, current_period_start, current_period_end, cancel_at, created_at
 		FROM subscriptions
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -53,7 +54,7 @@ func (r *SubscriptionRepository) ListByUser(ctx context.Context, userID string)
 	var subs []Subscription
 	for rows.Next() {
 		var sub Subscription
-		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.ComplexID, &sub.PlanID, &sub.Status, &sub.AddressJSON, &sub.TimeWindow, &sub.Instructions, &sub.CurrentPeriodStart, &sub.CurrentPeriodEnd, &sub.CreatedAt); err != nil {
+		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.ComplexID, &sub.PlanID, &sub.Status, &sub.AddressJSON, &sub.TimeWindow, &sub.Instructions, &sub.CurrentPeriodStart, &sub.CurrentPeriodEnd, &sub.CancelAt, &sub.CreatedAt); err != nil {
 			return nil, err
 		}
 		subs = append(subs, sub)
@@ -70,7 +71,7 @@ func (r *SubscriptionRepository) UpdateStatus(ctx context.Context, id, status st
 
 func (r *SubscriptionRepository) ListAll(ctx context.Context) ([]Subscription, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, user_id, complex_id, plan_id, status, address_json, time_window, instructions, current_period_start, current_period_end, created_at
+		SELECT id, user_id, complex_id, plan_id, status, address_json, time_window, instructions, current_period_start, current_period_end, cancel_at, created_at
 		FROM subscriptions
 		ORDER BY created_at DESC
 	`)
@@ -82,7 +83,7 @@ func (r *SubscriptionRepository) ListAll(ctx context.Context) ([]Subscription, e
 	var subs []Subscription
 	for rows.Next() {
 		var sub Subscription
-		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.ComplexID, &sub.PlanID, &sub.Status, &sub.AddressJSON, &sub.TimeWindow, &sub.Instructions, &sub.CurrentPeriodStart, &sub.CurrentPeriodEnd, &sub.CreatedAt); err != nil {
+		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.ComplexID, &sub.PlanID, &sub.Status, &sub.AddressJSON, &sub.TimeWindow, &sub.Instructions, &sub.CurrentPeriodStart, &sub.CurrentPeriodEnd, &sub.CancelAt, &sub.CreatedAt); err != nil {
 			return nil, err
 		}
 		subs = append(subs, sub)
@@ -93,9 +94,9 @@ func (r *SubscriptionRepository) ListAll(ctx context.Context) ([]Subscription, e
 func (r *SubscriptionRepository) Get(ctx context.Context, id string) (Subscription, error) {
 	var sub Subscription
 	err := r.db.QueryRowContext(ctx, `
-		SELECT id, user_id, complex_id, plan_id, status, address_json, time_window, instructions, current_period_start, current_period_end, created_at
+		SELECT id, user_id, complex_id, plan_id, status, address_json, time_window, instructions, current_period_start, current_period_end, cancel_at, created_at
 		FROM subscriptions
 		WHERE id = $1
-	`, id).Scan(&sub.ID, &sub.UserID, &sub.ComplexID, &sub.PlanID, &sub.Status, &sub.AddressJSON, &sub.TimeWindow, &sub.Instructions, &sub.CurrentPeriodStart, &sub.CurrentPeriodEnd, &sub.CreatedAt)
+	`, id).Scan(&sub.ID, &sub.UserID, &sub.ComplexID, &sub.PlanID, &sub.Status, &sub.AddressJSON, &sub.TimeWindow, &sub.Instructions, &sub.CurrentPeriodStart, &sub.CurrentPeriodEnd, &sub.CancelAt, &sub.CreatedAt)
 	return sub, err
 }
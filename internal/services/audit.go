@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"nesta/internal/audit"
+)
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+)
+
+// auditTables maps audited entities to the table their snapshots are read from.
+var auditTables = map[string]string{
+	"complex":      "residential_complexes",
+	"plan":         "plans",
+	"subscription": "subscriptions",
+	"product":      "products",
+	"order":        "orders",
+	"payment":      "payments",
+	"pickup_log":   "pickup_logs",
+	"user":         "users",
+}
+
+// auditQueries snapshot entities that span several tables; like a table
+// snapshot they take the id as $1 and return one JSON row.
+var auditQueries = map[string]string{
+	"courier": `
+		SELECT jsonb_build_object(
+			'id', u.id,
+			'role', u.role,
+			'complex_ids', COALESCE((SELECT jsonb_agg(cc.complex_id ORDER BY cc.complex_id) FROM courier_complexes cc WHERE cc.courier_id = u.id), '[]'::jsonb)
+		)
+		FROM users u WHERE u.id = $1`,
+}
+
+type AuditService struct {
+	DB *sql.DB
+}
+
+// Do runs fn in a transaction. When the context carries an admin actor, the
+// entity row is captured before and after fn and an audit_logs row is written
+// in the same transaction, so the change and its record commit together.
+func (s *AuditService) Do(ctx context.Context, entity, entityID, action string, fn func(tx *sql.Tx) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	finish, err := auditTx(ctx, tx, entity, entityID, action)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		return err
+	}
+	if err = finish(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// auditTx snapshots the entity and returns a func that snapshots it again
+// and writes the audit row. Without an admin actor in ctx both are no-ops.
+func auditTx(ctx context.Context, tx *sql.Tx, entity, entityID, action string) (func() error, error) {
+	actor, ok := audit.ActorFrom(ctx)
+	if !ok {
+		return func() error { return nil }, nil
+	}
+
+	before, err := snapshotTx(ctx, tx, entity, entityID)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		after, err := snapshotTx(ctx, tx, entity, entityID)
+		if err != nil {
+			return err
+		}
+		id, err := NewID()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO audit_logs (id, admin_id, entity, entity_id, action, before_json, after_json, ip, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, id, actor.AdminID, entity, entityID, action, before, after, nullString(actor.IP), time.Now())
+		return err
+	}, nil
+}
+
+// snapshotTx returns the row as JSON, or nil when it does not exist.
+func snapshotTx(ctx context.Context, tx *sql.Tx, entity, entityID string) ([]byte, error) {
+	query, ok := auditQueries[entity]
+	if !ok {
+		table, ok := auditTables[entity]
+		if !ok {
+			return nil, fmt.Errorf("unknown audit entity %q", entity)
+		}
+		query = `SELECT to_jsonb(t) FROM ` + table + ` t WHERE id = $1`
+	}
+	var snapshot []byte
+	err := tx.QueryRowContext(ctx, query, entityID).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return snapshot, err
+}
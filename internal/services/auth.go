@@ -2,28 +2,62 @@ package services
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/rand"
+	"math/big"
+	"strings"
 	"time"
 
 	"nesta/internal/auth"
+	"nesta/internal/phone"
 	"nesta/internal/repositories"
 )
 
+type OTPSender interface {
+	SendOTP(ctx context.Context, phone, code string) error
+}
+
+var ErrOTPDelivery = errors.New("otp delivery failed")
+
+var (
+	ErrRefreshInvalid = errors.New("invalid refresh")
+	ErrRefreshReused  = errors.New("refresh reused")
+)
+
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return "rate limited"
+}
+
 type AuthService struct {
+	DB             *sql.DB
+	Sender         OTPSender
 	Users          *repositories.UserRepository
 	OTP            *repositories.OTPRepository
 	RefreshTokens  *repositories.RefreshTokenRepository
-	JWTSecret      string
+	Keys           *auth.KeySet
 	AccessTTL      time.Duration
 	RefreshTTL     time.Duration
 	OTPTTL         time.Duration
 	OTPRateLimit   time.Duration
 	OTPMaxAttempts int
+	OTPPepper      string
+	PhoneCountry   string
+
+	// RefreshGrace is how long a just-rotated refresh token still returns
+	// its successor instead of counting as reuse. RefreshSecret derives the
+	// successor, so it can be returned again without being stored.
+	RefreshGrace  time.Duration
+	RefreshSecret string
 }
 
 type OTPResult struct {
@@ -31,29 +65,49 @@ type OTPResult struct {
 	ExpiresAt time.Time
 }
 
+// SessionInfo describes the client a session was opened or refreshed from.
+type SessionInfo struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
 type TokenPair struct {
 	AccessToken  string
 	RefreshToken string
 	ExpiresAt    time.Time
 }
 
-func (s *AuthService) SendOTP(ctx context.Context, phone string) (OTPResult, error) {
-	if phone == "" {
-		return OTPResult{}, errors.New("phone required")
+// NormalizePhone brings a user-entered number to the E.164 form phones are stored in.
+func (s *AuthService) NormalizePhone(raw string) (string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return "", errors.New("phone required")
+	}
+	return phone.Normalize(raw, s.PhoneCountry)
+}
+
+func (s *AuthService) SendOTP(ctx context.Context, rawPhone string) (OTPResult, error) {
+	phone, err := s.NormalizePhone(rawPhone)
+	if err != nil {
+		return OTPResult{}, err
 	}
 
 	latest, err := s.OTP.LatestByPhone(ctx, phone)
 	if err == nil {
-		if time.Since(latest.CreatedAt) < s.OTPRateLimit {
-			return OTPResult{}, errors.New("rate limited")
+		if wait := s.OTPRateLimit - time.Since(latest.CreatedAt); wait > 0 {
+			return OTPResult{}, &RateLimitError{RetryAfter: wait}
 		}
 		if latest.BlockedUntil.Valid && latest.BlockedUntil.Time.After(time.Now()) {
 			return OTPResult{}, errors.New("blocked")
 		}
 	}
 
-	code := fmt.Sprintf("%06d", rand.Intn(1000000))
-	codeHash := hashOTP(code)
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return OTPResult{}, err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	codeHash := s.hashOTP(phone, code)
 	id, err := NewID()
 	if err != nil {
 		return OTPResult{}, err
@@ -70,10 +124,25 @@ func (s *AuthService) SendOTP(ctx context.Context, phone string) (OTPResult, err
 		return OTPResult{}, err
 	}
 
+	if err := s.Sender.SendOTP(ctx, phone, code); err != nil {
+		// Without delivery the row would only block the next attempt via the rate limit.
+		_ = s.OTP.Delete(context.WithoutCancel(ctx), id)
+		return OTPResult{}, fmt.Errorf("%w: %v", ErrOTPDelivery, err)
+	}
+	// Earlier codes stay valid until the new one has actually been sent.
+	if err := s.OTP.InvalidateOutstanding(ctx, phone, id, time.Now()); err != nil {
+		return OTPResult{}, err
+	}
+
 	return OTPResult{Code: code, ExpiresAt: expiresAt}, nil
 }
 
-func (s *AuthService) VerifyOTP(ctx context.Context, phone, code string) (TokenPair, error) {
+func (s *AuthService) VerifyOTP(ctx context.Context, rawPhone, code string, info SessionInfo) (TokenPair, error) {
+	phone, err := s.NormalizePhone(rawPhone)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
 	latest, err := s.OTP.LatestByPhone(ctx, phone)
 	if err != nil {
 		return TokenPair{}, errors.New("otp not found")
@@ -81,19 +150,35 @@ func (s *AuthService) VerifyOTP(ctx context.Context, phone, code string) (TokenP
 	if latest.BlockedUntil.Valid && latest.BlockedUntil.Time.After(time.Now()) {
 		return TokenPair{}, errors.New("blocked")
 	}
+	if latest.UsedAt.Valid {
+		return TokenPair{}, errors.New("otp not found")
+	}
 	if latest.ExpiresAt.Before(time.Now()) {
 		return TokenPair{}, errors.New("otp expired")
 	}
 
-	if hashOTP(code) != latest.CodeHash {
-		attempts := latest.Attempts + 1
-		_ = s.OTP.IncrementAttempts(ctx, latest.ID, attempts)
+	if !hmac.Equal([]byte(s.hashOTP(phone, code)), []byte(latest.CodeHash)) {
+		attempts, err := s.OTP.IncrementAttempts(ctx, latest.ID)
+		if err != nil {
+			return TokenPair{}, err
+		}
 		if attempts >= s.OTPMaxAttempts {
 			_ = s.OTP.Block(ctx, latest.ID, time.Now().Add(s.OTPTTL))
 		}
 		return TokenPair{}, errors.New("invalid code")
 	}
 
+	consumed, err := s.OTP.Consume(ctx, latest.ID, time.Now())
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !consumed {
+		return TokenPair{}, errors.New("otp not found")
+	}
+	if err := s.OTP.InvalidateOutstanding(ctx, phone, latest.ID, time.Now()); err != nil {
+		return TokenPair{}, err
+	}
+
 	user, err := s.Users.FindByPhone(ctx, phone)
 	if err != nil {
 		if !errors.Is(err, sql.ErrNoRows) {
@@ -109,74 +194,275 @@ func (s *AuthService) VerifyOTP(ctx context.Context, phone, code string) (TokenP
 		}
 	}
 
-	return s.issueTokens(ctx, user.ID, user.Role)
+	return s.issueTokens(ctx, user.ID, user.Role, info)
 }
 
-func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
-	stored, err := s.RefreshTokens.FindByToken(ctx, refreshToken)
+// Refresh rotates the refresh token: the presented token is revoked and
+// replaced by a new one in the same family. Presenting an already revoked
+// token means it was copied, so the whole family is revoked.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string, info SessionInfo) (TokenPair, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var stored repositories.RefreshToken
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, family_id, replaced_by, device_name, expires_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, hashRefreshToken(refreshToken)).Scan(&stored.ID, &stored.UserID, &stored.FamilyID, &stored.ReplacedBy, &stored.DeviceName, &stored.ExpiresAt, &stored.RevokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrRefreshInvalid
+		return TokenPair{}, err
+	}
 	if err != nil {
-		return TokenPair{}, errors.New("invalid refresh")
+		return TokenPair{}, err
 	}
+
+	now := time.Now()
 	if stored.RevokedAt.Valid {
-		return TokenPair{}, errors.New("refresh revoked")
+		// A retry after a lost response, or another tab refreshing at the
+		// same moment, gets the same successor back.
+		var pair TokenPair
+		if pair, err = s.replayRotation(ctx, tx, stored, refreshToken, now); err != nil {
+			return TokenPair{}, err
+		}
+		if pair.RefreshToken != "" {
+			if err = tx.Commit(); err != nil {
+				return TokenPair{}, err
+			}
+			return pair, nil
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL
+		`, stored.FamilyID, now)
+		if err != nil {
+			return TokenPair{}, err
+		}
+		if err = tx.Commit(); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrRefreshReused
+	}
+	if stored.ExpiresAt.Before(now) {
+		err = errors.New("refresh expired")
+		return TokenPair{}, err
 	}
-	if stored.ExpiresAt.Before(time.Now()) {
-		return TokenPair{}, errors.New("refresh expired")
+
+	var role string
+	if err = tx.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1`, stored.UserID).Scan(&role); err != nil {
+		return TokenPair{}, err
 	}
 
-	user, err := s.Users.FindByID(ctx, stored.UserID)
+	if info.DeviceName == "" {
+		info.DeviceName = stored.DeviceName.String
+	}
+	next, value, err := s.newRefreshToken(stored.UserID, stored.FamilyID, s.successorToken(refreshToken), info, now)
 	if err != nil {
 		return TokenPair{}, err
 	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (id, user_id, token_hash, family_id, device_name, user_agent, ip, expires_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, next.ID, next.UserID, next.TokenHash, next.FamilyID, next.DeviceName, next.UserAgent, next.IP, next.ExpiresAt, next.LastUsedAt)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = $2, replaced_by = $3 WHERE id = $1
+	`, stored.ID, now, next.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	accessToken, expiresAt, err := s.newAccessToken(stored.UserID, role, stored.FamilyID, now)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{AccessToken: accessToken, RefreshToken: value, ExpiresAt: expiresAt}, nil
+}
+
+// replayRotation returns the live successor of a token rotated less than
+// RefreshGrace ago, following the chain if the successor was rotated as
+// well. It returns an empty pair when the token does not qualify, which the
+// caller treats as reuse.
+func (s *AuthService) replayRotation(ctx context.Context, tx *sql.Tx, stored repositories.RefreshToken, value string, now time.Time) (TokenPair, error) {
+	for step := 0; step < 5; step++ {
+		if !stored.ReplacedBy.Valid || !stored.RevokedAt.Valid || now.Sub(stored.RevokedAt.Time) >= s.RefreshGrace {
+			return TokenPair{}, nil
+		}
+		value = s.successorToken(value)
+		var tokenHash string
+		successorID := stored.ReplacedBy.String
+		stored = repositories.RefreshToken{}
+		err := tx.QueryRowContext(ctx, `
+			SELECT id, user_id, family_id, replaced_by, token_hash, expires_at, revoked_at
+			FROM refresh_tokens
+			WHERE id = $1
+			FOR UPDATE
+		`, successorID).Scan(&stored.ID, &stored.UserID, &stored.FamilyID, &stored.ReplacedBy, &tokenHash, &stored.ExpiresAt, &stored.RevokedAt)
+		if err != nil {
+			return TokenPair{}, err
+		}
+		// Successors issued before RefreshSecret changed cannot be derived.
+		if !hmac.Equal([]byte(tokenHash), []byte(hashRefreshToken(value))) {
+			return TokenPair{}, nil
+		}
+		if stored.RevokedAt.Valid {
+			continue
+		}
+		if stored.ExpiresAt.Before(now) {
+			return TokenPair{}, nil
+		}
 
-	return s.issueTokens(ctx, user.ID, user.Role)
+		var role string
+		if err := tx.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1`, stored.UserID).Scan(&role); err != nil {
+			return TokenPair{}, err
+		}
+		accessToken, expiresAt, err := s.newAccessToken(stored.UserID, role, stored.FamilyID, now)
+		if err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{AccessToken: accessToken, RefreshToken: value, ExpiresAt: expiresAt}, nil
+	}
+	return TokenPair{}, nil
 }
 
+// Logout revokes the token together with the rest of its family.
 func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
-	return s.RefreshTokens.Revoke(ctx, refreshToken, time.Now())
+	stored, err := s.RefreshTokens.FindByHash(ctx, hashRefreshToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.RefreshTokens.RevokeFamily(ctx, stored.FamilyID, time.Now())
 }
 
-func (s *AuthService) issueTokens(ctx context.Context, userID, role string) (TokenPair, error) {
-	accessID, err := NewID()
+// issueTokens starts a new refresh token family (session) after a login.
+func (s *AuthService) issueTokens(ctx context.Context, userID, role string, info SessionInfo) (TokenPair, error) {
+	now := time.Now()
+	refresh, value, err := s.newRefreshToken(userID, "", "", info, now)
 	if err != nil {
 		return TokenPair{}, err
 	}
-	refreshID, err := NewID()
+	accessToken, expiresAt, err := s.newAccessToken(userID, role, refresh.FamilyID, now)
 	if err != nil {
 		return TokenPair{}, err
 	}
+	if err := s.RefreshTokens.Create(ctx, refresh); err != nil {
+		return TokenPair{}, err
+	}
 
-	issuedAt := time.Now()
+	return TokenPair{AccessToken: accessToken, RefreshToken: value, ExpiresAt: expiresAt}, nil
+}
+
+func (s *AuthService) Sessions(ctx context.Context, userID string) ([]repositories.Session, error) {
+	return s.RefreshTokens.ListSessions(ctx, userID)
+}
+
+// RevokeSession ends one session; access tokens issued for it stop working
+// on the next request.
+func (s *AuthService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return s.RefreshTokens.RevokeSession(ctx, userID, sessionID, time.Now())
+}
+
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID string) (int64, error) {
+	return s.RefreshTokens.RevokeUser(ctx, userID, time.Now())
+}
+
+// SessionActive reports whether the session an access token belongs to has
+// not been revoked. It backs the session check in middleware.Auth.
+func (s *AuthService) SessionActive(ctx context.Context, sessionID string) (bool, error) {
+	return s.RefreshTokens.SessionActive(ctx, sessionID)
+}
+
+func (s *AuthService) newAccessToken(userID, role, sessionID string, issuedAt time.Time) (string, time.Time, error) {
+	accessID, err := NewID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
 	expiresAt := issuedAt.Add(s.AccessTTL)
-	accessToken, err := auth.NewToken(s.JWTSecret, auth.Claims{
+	token, err := s.Keys.NewToken(auth.Claims{
 		Subject: userID,
 		Role:    role,
 		Issued:  issuedAt.Unix(),
 		Expires: expiresAt.Unix(),
 		ID:      accessID,
+		Session: sessionID,
 	})
-	if err != nil {
-		return TokenPair{}, err
-	}
+	return token, expiresAt, err
+}
 
-	refreshTokenValue, err := NewID()
+// newRefreshToken returns the row to store and the plaintext value handed to
+// the client; only the hash is persisted. An empty familyID starts a new family.
+// newRefreshToken builds a token row; an empty value is generated randomly.
+func (s *AuthService) newRefreshToken(userID, familyID, value string, info SessionInfo, issuedAt time.Time) (repositories.RefreshToken, string, error) {
+	id, err := NewID()
 	if err != nil {
-		return TokenPair{}, err
+		return repositories.RefreshToken{}, "", err
 	}
-
-	if err := s.RefreshTokens.Create(ctx, repositories.RefreshToken{
-		ID:        refreshID,
-		UserID:    userID,
-		Token:     refreshTokenValue,
-		ExpiresAt: issuedAt.Add(s.RefreshTTL),
-	}); err != nil {
-		return TokenPair{}, err
+	if value == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return repositories.RefreshToken{}, "", err
+		}
+		value = base64.RawURLEncoding.EncodeToString(buf)
 	}
-
-	return TokenPair{AccessToken: accessToken, RefreshToken: refreshTokenValue, ExpiresAt: expiresAt}, nil
+	if familyID == "" {
+		familyID = id
+	}
+	return repositories.RefreshToken{
+		ID:         id,
+		UserID:     userID,
+		TokenHash:  hashRefreshToken(value),
+		FamilyID:   familyID,
+		DeviceName: nullString(truncate(info.DeviceName, 100)),
+		UserAgent:  nullString(truncate(info.UserAgent, 255)),
+		IP:         nullString(info.IP),
+		ExpiresAt:  issuedAt.Add(s.RefreshTTL),
+		LastUsedAt: issuedAt,
+	}, value, nil
 }
 
-func hashOTP(code string) string {
-	sum := sha256.Sum256([]byte(code))
+// Refresh tokens are random 256-bit values, so a plain SHA-256 is enough to
+// keep a leaked table from being replayed.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
 	return hex.EncodeToString(sum[:])
 }
+
+// successorToken derives the token that replaces token on rotation. It is
+// keyed with RefreshSecret, so a stolen token does not reveal its successor.
+func (s *AuthService) successorToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(s.RefreshSecret))
+	mac.Write([]byte("refresh:" + token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hashOTP binds the code to the phone and keys it with a server-side pepper,
+// so a leaked otp_codes table cannot be brute-forced offline.
+func (s *AuthService) hashOTP(phone, code string) string {
+	mac := hmac.New(sha256.New, []byte(s.OTPPepper))
+	mac.Write([]byte(phone + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func truncate(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) > max {
+		return string(runes[:max])
+	}
+	return value
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"nesta/internal/frequency"
+	"nesta/internal/payments"
+	"nesta/internal/repositories"
+)
+
+type BillingService struct {
+	DB          *sql.DB
+	Providers   *payments.Registry
+	Provider    string
+	Currency    string
+	RenewBefore time.Duration
+	GracePeriod time.Duration
+	BatchSize   int
+}
+
+type renewalCandidate struct {
+	SubscriptionID string
+	PeriodEnd      time.Time
+	PriceCents     int
+	Frequency      string
+}
+
+// Rows are claimed with SKIP LOCKED and payments are unique per
+// (subscription, period_start), so several replicas can renew concurrently.
+// It returns the renewal payments it created.
+func (s *BillingService) CreateRenewals(ctx context.Context, now time.Time) ([]repositories.Payment, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT s.id, s.current_period_end, p.price_cents, p.frequency
+		FROM subscriptions s
+		LEFT JOIN subscription_plan_changes c ON c.subscription_id = s.id
+			AND c.status = 'PENDING' AND c.kind = 'DOWNGRADE' AND c.effective_at = s.current_period_end
+		JOIN plans p ON p.id = COALESCE(c.to_plan_id, s.plan_id)
+		WHERE s.status = 'ACTIVE'
+			AND s.cancel_at IS NULL
+			AND s.current_period_end IS NOT NULL
+			AND s.current_period_end <= $1
+			AND NOT EXISTS (
+				SELECT 1 FROM payments pay
+				WHERE pay.type = 'subscription' AND pay.entity_id = s.id AND pay.period_start = s.current_period_end
+			)
+		ORDER BY s.current_period_end
+		LIMIT $2
+		FOR UPDATE OF s SKIP LOCKED
+	`, now.Add(s.RenewBefore), s.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []renewalCandidate
+	for rows.Next() {
+		var item renewalCandidate
+		if err = rows.Scan(&item.SubscriptionID, &item.PeriodEnd, &item.PriceCents, &item.Frequency); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var created []repositories.Payment
+	for _, item := range candidates {
+		var freq frequency.Frequency
+		freq, err = frequency.Parse(item.Frequency)
+		if err != nil {
+			return nil, err
+		}
+		periodStart := item.PeriodEnd
+		periodEnd := freq.Next(periodStart)
+
+		if item.PriceCents == 0 {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE subscriptions SET current_period_start = $2, current_period_end = $3 WHERE id = $1
+			`, item.SubscriptionID, periodStart, periodEnd)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var payment repositories.Payment
+		payment, err = newPayment("subscription", item.SubscriptionID, s.Provider, item.PriceCents)
+		if err != nil {
+			return nil, err
+		}
+		payment.PeriodStart = sql.NullTime{Time: periodStart, Valid: true}
+		payment.PeriodEnd = sql.NullTime{Time: periodEnd, Valid: true}
+
+		// The payment id doubles as the merchant reference until the provider assigns its own.
+		var result sql.Result
+		result, err = tx.ExecContext(ctx, `
+			INSERT INTO payments (id, type, entity_id, provider, provider_payment_id, status, amount_cents, period_start, period_end)
+			VALUES ($1, 'subscription', $2, $3, $1, 'INIT', $4, $5, $6)
+			ON CONFLICT DO NOTHING
+		`, payment.ID, item.SubscriptionID, s.Provider, item.PriceCents, periodStart, periodEnd)
+		if err != nil {
+			return nil, err
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			created = append(created, payment)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ChargeRenewals charges the card saved with the subscription's last paid
+// payment, when the provider supports it. The outcome arrives as a webhook.
+// Without a saved card, or when the charge fails, the payment stays INIT and
+// the user pays it through /payments/init.
+func (s *BillingService) ChargeRenewals(ctx context.Context, renewals []repositories.Payment) error {
+	if s.Providers == nil {
+		return nil
+	}
+	provider, err := s.Providers.Get(s.Provider)
+	if err != nil {
+		return err
+	}
+	charger, ok := provider.(payments.Charger)
+	if !ok {
+		return nil
+	}
+
+	var errs []error
+	for _, payment := range renewals {
+		var payload []byte
+		err := s.DB.QueryRowContext(ctx, `
+			SELECT payload_json FROM payments
+			WHERE type = 'subscription' AND entity_id = $1 AND provider = $2 AND status = 'PAID' AND payload_json IS NOT NULL
+			ORDER BY created_at DESC
+			LIMIT 1
+		`, payment.EntityID, payment.Provider).Scan(&payload)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		err = charger.Charge(ctx, payments.ChargeRequest{
+			PaymentID:         payment.ID,
+			ProviderPaymentID: payment.ProviderPayment.String,
+			AmountCents:       payment.AmountCents,
+			Currency:          s.Currency,
+			Description:       paymentDescription(payment),
+			Payload:           payload,
+		})
+		if errors.Is(err, payments.ErrNotSupported) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+		// Even a failed call may have reached the provider.
+		if _, err := s.DB.ExecContext(ctx, `UPDATE payments SET checkout_at = COALESCE(checkout_at, $2) WHERE id = $1`, payment.ID, time.Now()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *BillingService) ExpireOverdue(ctx context.Context, now time.Time) error {
+	_, err := transitionMatching(ctx, s.DB, ActionOverdue, "period ended unpaid", `
+		SELECT id, status FROM subscriptions
+		WHERE status = 'ACTIVE' AND cancel_at IS NULL AND current_period_end < $1
+		FOR UPDATE SKIP LOCKED
+	`, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = transitionMatching(ctx, s.DB, ActionExpire, "grace period ended", `
+		SELECT id, status FROM subscriptions
+		WHERE status = 'PAST_DUE' AND current_period_end < $1
+		FOR UPDATE SKIP LOCKED
+	`, now.Add(-s.GracePeriod))
+	return err
+}
+
+func (s *BillingService) Run(ctx context.Context) error {
+	now := time.Now()
+	if err := s.ApplyPlanChanges(ctx, now); err != nil {
+		return err
+	}
+	renewals, err := s.CreateRenewals(ctx, now)
+	if err != nil {
+		return err
+	}
+	// A failed charge must not hold up the overdue transitions.
+	chargeErr := s.ChargeRenewals(ctx, renewals)
+	if err := s.ExpireOverdue(ctx, now); err != nil {
+		return err
+	}
+	return chargeErr
+}
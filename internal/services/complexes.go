@@ -6,6 +6,7 @@ import (
 	"errors"
 	"time"
 
+	"nesta/internal/phone"
 	"nesta/internal/repositories"
 )
 
@@ -14,9 +15,15 @@ type ComplexService struct {
 	Complexes       *repositories.ComplexRepository
 	Requests        *repositories.ComplexRequestRepository
 	ThresholdStatus string
+	PhoneCountry    string
 }
 
-func (s *ComplexService) CreateRequest(ctx context.Context, complexID, phone string) (repositories.ComplexRequest, repositories.ResidentialComplex, error) {
+func (s *ComplexService) CreateRequest(ctx context.Context, complexID, rawPhone string) (repositories.ComplexRequest, repositories.ResidentialComplex, error) {
+	phone, err := phone.Normalize(rawPhone, s.PhoneCountry)
+	if err != nil {
+		return repositories.ComplexRequest{}, repositories.ResidentialComplex{}, err
+	}
+
 	request, err := s.Requests.FindByComplexAndPhone(ctx, complexID, phone)
 	if err == nil {
 		if request.Verified {
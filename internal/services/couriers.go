@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"nesta/internal/auth"
+	"nesta/internal/repositories"
+)
+
+const PickupSkipped = "SKIPPED"
+
+var PickupReasons = []string{"NO_ACCESS", "NO_BAGS", "CUSTOMER_ABSENT", "WRONG_ADDRESS", "CUSTOMER_REQUEST", "OTHER"}
+
+var (
+	ErrPickupCompleted = errors.New("pickup already completed")
+	ErrPickupNotToday  = errors.New("only today's pickups can be marked")
+)
+
+type CourierService struct {
+	DB       *sql.DB
+	Couriers *repositories.CourierRepository
+}
+
+type RouteComplex struct {
+	ComplexID   string
+	ComplexName string
+	Stops       []RouteStop
+}
+
+type RouteStop struct {
+	PickupID       string
+	SubscriptionID string
+	Building       string
+	Entrance       string
+	Floor          string
+	Apartment      string
+	Address        map[string]any
+	TimeWindow     string
+	Instructions   string
+	Status         string
+	Reason         string
+}
+
+type PickupMark struct {
+	Status   string
+	Reason   string
+	Comment  string
+	PhotoRef string
+}
+
+// Route returns the courier's pickups for date grouped by complex and ordered
+// the way the building is walked: building, entrance, floor, apartment.
+func (s *CourierService) Route(ctx context.Context, courierID string, date time.Time) ([]RouteComplex, error) {
+	stops, err := s.Couriers.ListRoute(ctx, courierID, dateOf(date))
+	if err != nil {
+		return nil, err
+	}
+
+	route := []RouteComplex{}
+	for _, item := range stops {
+		stop := RouteStop{
+			PickupID:       item.PickupID,
+			SubscriptionID: item.SubscriptionID,
+			TimeWindow:     item.TimeWindow.String,
+			Instructions:   item.Instructions.String,
+			Status:         item.Status,
+			Reason:         item.Reason.String,
+		}
+		if err := json.Unmarshal(item.AddressJSON, &stop.Address); err == nil {
+			stop.Building = addressField(stop.Address, "building", "house")
+			stop.Entrance = addressField(stop.Address, "entrance")
+			stop.Floor = addressField(stop.Address, "floor")
+			stop.Apartment = addressField(stop.Address, "apartment", "flat")
+		}
+
+		if len(route) == 0 || route[len(route)-1].ComplexID != item.ComplexID {
+			route = append(route, RouteComplex{ComplexID: item.ComplexID, ComplexName: item.ComplexName})
+		}
+		route[len(route)-1].Stops = append(route[len(route)-1].Stops, stop)
+	}
+
+	for _, group := range route {
+		sort.SliceStable(group.Stops, func(i, j int) bool {
+			a, b := group.Stops[i], group.Stops[j]
+			for _, pair := range [][2]string{{a.Building, b.Building}, {a.Entrance, b.Entrance}, {a.Floor, b.Floor}, {a.Apartment, b.Apartment}} {
+				if pair[0] != pair[1] {
+					return naturalLess(pair[0], pair[1])
+				}
+			}
+			return false
+		})
+	}
+	return route, nil
+}
+
+// Mark records the outcome of a planned pickup due today. FAILED and SKIPPED
+// require a reason from PickupReasons.
+func (s *CourierService) Mark(ctx context.Context, courierID, pickupID string, mark PickupMark) (repositories.PickupLog, error) {
+	switch mark.Status {
+	case PickupDone:
+	case PickupFailed, PickupSkipped:
+		if mark.Reason == "" {
+			return repositories.PickupLog{}, errors.New("reason required")
+		}
+	default:
+		return repositories.PickupLog{}, errors.New("invalid status")
+	}
+	if mark.Reason != "" && !validPickupReason(mark.Reason) {
+		return repositories.PickupLog{}, errors.New("invalid reason")
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.PickupLog{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var log repositories.PickupLog
+	err = tx.QueryRowContext(ctx, `
+		SELECT p.id, p.subscription_id, p.pickup_date, p.status, p.time_window
+		FROM pickup_logs p
+		JOIN subscriptions s ON s.id = p.subscription_id
+		JOIN courier_complexes cc ON cc.complex_id = s.complex_id AND cc.courier_id = $2
+		WHERE p.id = $1
+		FOR UPDATE OF p
+	`, pickupID, courierID).Scan(&log.ID, &log.SubscriptionID, &log.PickupDate, &log.Status, &log.TimeWindow)
+	if err != nil {
+		return repositories.PickupLog{}, err
+	}
+	if log.Status != PickupPlanned {
+		err = ErrPickupCompleted
+		return repositories.PickupLog{}, err
+	}
+	if !dateOf(log.PickupDate).Equal(dateOf(time.Now())) {
+		err = ErrPickupNotToday
+		return repositories.PickupLog{}, err
+	}
+
+	from := log.Status
+	log.Status = mark.Status
+	log.Reason = nullString(mark.Reason)
+	log.Comment = nullString(mark.Comment)
+	log.PhotoRef = nullString(mark.PhotoRef)
+	log.CourierID = nullString(courierID)
+	log.CompletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE pickup_logs
+		SET status = $2, comment = $3, reason = $4, photo_ref = $5, courier_id = $6, completed_at = $7
+		WHERE id = $1
+	`, log.ID, log.Status, log.Comment, log.Reason, log.PhotoRef, log.CourierID, log.CompletedAt)
+	if err != nil {
+		return repositories.PickupLog{}, err
+	}
+	if err = insertPickupHistory(ctx, tx, log, from, courierID, PickupSourceCourier); err != nil {
+		return repositories.PickupLog{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return repositories.PickupLog{}, err
+	}
+	return log, nil
+}
+
+// AssignComplexes gives the user the courier role and replaces the set of
+// complexes they serve. Promoting a user revokes their sessions, like
+// RoleService.SetRole does on a role change. The change is audited as a
+// courier, whose snapshot includes the assigned complexes.
+func (s *CourierService) AssignComplexes(ctx context.Context, userID string, complexIDs []string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var role string
+	if err = tx.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&role); err != nil {
+		return err
+	}
+	if role != auth.RoleUser && role != auth.RoleCourier {
+		err = errors.New("staff cannot be a courier")
+		return err
+	}
+	finish, err := auditTx(ctx, tx, "courier", userID, AuditUpdate)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE users SET role = 'courier' WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	if role == auth.RoleUser {
+		// Tokens issued before the promotion still carry the user role.
+		_, err = tx.ExecContext(ctx, `
+			UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL
+		`, userID, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM courier_complexes WHERE courier_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, complexID := range complexIDs {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO courier_complexes (courier_id, complex_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, userID, complexID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = finish(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func validPickupReason(reason string) bool {
+	for _, item := range PickupReasons {
+		if item == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func addressField(address map[string]any, keys ...string) string {
+	for _, key := range keys {
+		switch value := address[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		case nil:
+		default:
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
+
+// naturalLess orders "2" before "10"; non-numeric values fall back to string order.
+func naturalLess(a, b string) bool {
+	left, leftErr := strconv.Atoi(a)
+	right, rightErr := strconv.Atoi(b)
+	if leftErr == nil && rightErr == nil {
+		return left < right
+	}
+	return a < b
+}
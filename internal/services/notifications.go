@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Notification kinds written for users.
+const (
+	NotificationPaymentExpired = "payment_expired"
+	NotificationRefundRequired = "payment_refund_required"
+)
+
+func notifyTx(ctx context.Context, tx *sql.Tx, userID, kind, entity, entityID string, payload any) error {
+	id, err := NewID()
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO notifications (id, user_id, kind, entity, entity_id, payload_json, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, id, userID, kind, entity, entityID, raw, time.Now())
+	return err
+}
+
+// paymentOwnerTx returns the user the payment's order, subscription or plan
+// change belongs to.
+func paymentOwnerTx(ctx context.Context, tx *sql.Tx, paymentType, entityID string) (string, error) {
+	query := `SELECT user_id FROM subscriptions WHERE id = $1`
+	switch paymentType {
+	case "order":
+		query = `SELECT user_id FROM orders WHERE id = $1`
+	case "plan_change":
+		query = `
+			SELECT s.user_id FROM subscription_plan_changes c JOIN subscriptions s ON s.id = c.subscription_id
+			WHERE c.id = $1`
+	}
+	var userID string
+	err := tx.QueryRowContext(ctx, query, entityID).Scan(&userID)
+	return userID, err
+}
@@ -2,12 +2,14 @@ package services
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 
 	"nesta/internal/repositories"
 )
 
 type OrderService struct {
+	DB       *sql.DB
 	Orders   *repositories.OrderRepository
 	Products *repositories.ProductRepository
 }
@@ -65,7 +67,20 @@ func (s *OrderService) Create(ctx context.Context, userID string, address []byte
 		order.Comment.String = comment
 	}
 
-	if err := s.Orders.Create(ctx, order, orderItems); err != nil {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.Order{}, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = s.Orders.WithTx(tx).Create(ctx, order, orderItems); err != nil {
+		return repositories.Order{}, nil, err
+	}
+	if err = tx.Commit(); err != nil {
 		return repositories.Order{}, nil, err
 	}
 
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"nesta/internal/repositories"
+)
+
+const (
+	PauseScheduled = "SCHEDULED"
+	PauseActive    = "ACTIVE"
+	PauseCompleted = "COMPLETED"
+	PauseCanceled  = "CANCELED"
+)
+
+// PauseRequest with a zero Until is an open-ended pause that lasts until resume.
+type PauseRequest struct {
+	From  time.Time
+	Until time.Time
+}
+
+func (s *SubscriptionService) Pause(ctx context.Context, id string, req PauseRequest, actorID string) (repositories.Subscription, error) {
+	today := dateOf(time.Now())
+	from := dateOf(req.From)
+	if req.From.IsZero() {
+		from = today
+	}
+	if from.Before(today) {
+		return repositories.Subscription{}, errors.New("pause cannot start in the past")
+	}
+	var until sql.NullTime
+	if !req.Until.IsZero() {
+		until = sql.NullTime{Time: dateOf(req.Until), Valid: true}
+		if !until.Time.After(from) {
+			return repositories.Subscription{}, errors.New("pause must end after it starts")
+		}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var (
+		status       string
+		periodStart  sql.NullTime
+		maxPauseDays sql.NullInt64
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT s.status, s.current_period_start, p.max_pause_days
+		FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		WHERE s.id = $1
+		FOR UPDATE OF s
+	`, id).Scan(&status, &periodStart, &maxPauseDays)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	finish, err := auditTx(ctx, tx, "subscription", id, string(ActionPause))
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if _, err = NextSubscriptionStatus(status, ActionPause); err != nil {
+		return repositories.Subscription{}, err
+	}
+
+	var open int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM subscription_pauses WHERE subscription_id = $1 AND status IN ('SCHEDULED', 'ACTIVE')
+	`, id).Scan(&open)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if open > 0 {
+		err = errors.New("pause already scheduled")
+		return repositories.Subscription{}, err
+	}
+
+	if maxPauseDays.Valid {
+		if !until.Valid {
+			err = errors.New("pause end date required")
+			return repositories.Subscription{}, err
+		}
+		var used int64
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(ends_on - starts_on), 0)
+			FROM subscription_pauses
+			WHERE subscription_id = $1 AND status <> 'CANCELED' AND ends_on IS NOT NULL AND ($2::timestamptz IS NULL OR starts_on >= $2::date)
+		`, id, periodStart).Scan(&used)
+		if err != nil {
+			return repositories.Subscription{}, err
+		}
+		if used+daysBetween(from, until.Time) > maxPauseDays.Int64 {
+			err = errors.New("pause limit exceeded")
+			return repositories.Subscription{}, err
+		}
+	}
+
+	pauseID, err := NewID()
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	pauseStatus := PauseScheduled
+	if from.Equal(today) {
+		pauseStatus = PauseActive
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO subscription_pauses (id, subscription_id, starts_on, ends_on, status)
+		VALUES ($1, $2, $3, $4, $5)
+	`, pauseID, id, from, until, pauseStatus)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+
+	if pauseStatus == PauseActive {
+		if _, err = transitionTx(ctx, tx, id, status, ActionPause, actorID, ""); err != nil {
+			return repositories.Subscription{}, err
+		}
+	}
+
+	if err = finish(); err != nil {
+		return repositories.Subscription{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return repositories.Subscription{}, err
+	}
+	return s.Subscriptions.Get(ctx, id)
+}
+
+// Resume ends the running pause early, or drops a pause that has not started yet.
+func (s *SubscriptionService) Resume(ctx context.Context, id, actorID string) (repositories.Subscription, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var status string
+	if err = tx.QueryRowContext(ctx, `SELECT status FROM subscriptions WHERE id = $1 FOR UPDATE`, id).Scan(&status); err != nil {
+		return repositories.Subscription{}, err
+	}
+	finish, err := auditTx(ctx, tx, "subscription", id, string(ActionResume))
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+
+	var (
+		pauseID     string
+		pauseStatus string
+		startsOn    time.Time
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, status, starts_on FROM subscription_pauses
+		WHERE subscription_id = $1 AND status IN ('SCHEDULED', 'ACTIVE')
+		ORDER BY starts_on
+		LIMIT 1
+		FOR UPDATE
+	`, id).Scan(&pauseID, &pauseStatus, &startsOn)
+	hasPause := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return repositories.Subscription{}, err
+	}
+	err = nil
+
+	if hasPause && pauseStatus == PauseScheduled {
+		if _, err = tx.ExecContext(ctx, `UPDATE subscription_pauses SET status = 'CANCELED' WHERE id = $1`, pauseID); err != nil {
+			return repositories.Subscription{}, err
+		}
+	} else {
+		if _, err = transitionTx(ctx, tx, id, status, ActionResume, actorID, ""); err != nil {
+			return repositories.Subscription{}, err
+		}
+		if hasPause {
+			if err = completePause(ctx, tx, pauseID, id, startsOn, dateOf(time.Now())); err != nil {
+				return repositories.Subscription{}, err
+			}
+		}
+	}
+
+	if err = finish(); err != nil {
+		return repositories.Subscription{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return repositories.Subscription{}, err
+	}
+	return s.Subscriptions.Get(ctx, id)
+}
+
+// ApplyPauses starts scheduled pauses and resumes subscriptions whose pause
+// has reached its end date.
+func (s *SubscriptionService) ApplyPauses(ctx context.Context) error {
+	today := dateOf(time.Now())
+	if err := s.startPauses(ctx, today); err != nil {
+		return err
+	}
+	return s.finishPauses(ctx, today)
+}
+
+func (s *SubscriptionService) startPauses(ctx context.Context, today time.Time) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT p.id, p.subscription_id, s.status
+		FROM subscription_pauses p
+		JOIN subscriptions s ON s.id = p.subscription_id
+		WHERE p.status = 'SCHEDULED' AND p.starts_on <= $1
+		FOR UPDATE OF p, s SKIP LOCKED
+	`, today)
+	if err != nil {
+		return err
+	}
+	type due struct {
+		pauseID        string
+		subscriptionID string
+		status         string
+	}
+	var items []due
+	for rows.Next() {
+		var item due
+		if err = rows.Scan(&item.pauseID, &item.subscriptionID, &item.status); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		next := PauseActive
+		if _, transitionErr := NextSubscriptionStatus(item.status, ActionPause); transitionErr != nil {
+			next = PauseCanceled
+		} else if _, err = transitionTx(ctx, tx, item.subscriptionID, item.status, ActionPause, "", "scheduled pause"); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, `UPDATE subscription_pauses SET status = $2 WHERE id = $1`, item.pauseID, next); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SubscriptionService) finishPauses(ctx context.Context, today time.Time) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT p.id, p.subscription_id, p.starts_on, p.ends_on, s.status
+		FROM subscription_pauses p
+		JOIN subscriptions s ON s.id = p.subscription_id
+		WHERE p.status = 'ACTIVE' AND p.ends_on <= $1
+		FOR UPDATE OF p, s SKIP LOCKED
+	`, today)
+	if err != nil {
+		return err
+	}
+	type due struct {
+		pauseID        string
+		subscriptionID string
+		startsOn       time.Time
+		endsOn         time.Time
+		status         string
+	}
+	var items []due
+	for rows.Next() {
+		var item due
+		if err = rows.Scan(&item.pauseID, &item.subscriptionID, &item.startsOn, &item.endsOn, &item.status); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if item.status != SubscriptionPaused {
+			// Canceled or expired while paused: close the pause without resuming.
+			if _, err = tx.ExecContext(ctx, `UPDATE subscription_pauses SET status = 'COMPLETED' WHERE id = $1`, item.pauseID); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err = transitionTx(ctx, tx, item.subscriptionID, item.status, ActionResume, "", "pause ended"); err != nil {
+			return err
+		}
+		if err = completePause(ctx, tx, item.pauseID, item.subscriptionID, item.startsOn, item.endsOn); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// completePause closes a pause on endsOn and pushes current_period_end out by
+// the number of days actually paused, and a scheduled cancel_at with it so
+// it does not fire inside paid time. Open renewals were created for the old
+// period end: those never sent to the provider are expired and billing
+// creates one for the new end, while those already sent (a checkout or a
+// card charge may still complete) move with the period instead.
+func completePause(ctx context.Context, tx *sql.Tx, pauseID, subscriptionID string, startsOn, endsOn time.Time) error {
+	_, err := tx.ExecContext(ctx, `UPDATE subscription_pauses SET status = 'COMPLETED', ends_on = $2 WHERE id = $1`, pauseID, endsOn)
+	if err != nil {
+		return err
+	}
+
+	days := daysBetween(startsOn, endsOn)
+	if days <= 0 {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE subscriptions SET
+			current_period_end = current_period_end + make_interval(days => $2::int),
+			cancel_at = cancel_at + make_interval(days => $2::int)
+		WHERE id = $1 AND current_period_end IS NOT NULL
+	`, subscriptionID, days)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE payments SET status = $2
+		WHERE type = 'subscription' AND entity_id = $1 AND period_start IS NOT NULL AND status IN ('INIT', 'PENDING')
+			AND checkout_at IS NULL
+	`, subscriptionID, PaymentExpired)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE payments SET
+			period_start = period_start + make_interval(days => $2::int),
+			period_end = period_end + make_interval(days => $2::int)
+		WHERE type = 'subscription' AND entity_id = $1 AND period_start IS NOT NULL AND status IN ('INIT', 'PENDING')
+			AND checkout_at IS NOT NULL
+	`, subscriptionID, days)
+	return err
+}
+
+func dateOf(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func daysBetween(from, to time.Time) int64 {
+	return int64(dateOf(to).Sub(dateOf(from)).Hours() / 24)
+}
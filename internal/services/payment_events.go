@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"nesta/internal/payments"
+	"nesta/internal/repositories"
+)
+
+// Outcomes of a stored webhook. FAILED events, and RECEIVED ones left
+// unfinished by a crash or timeout, are retried when the provider redelivers
+// them.
+const (
+	EventReceived  = "RECEIVED"
+	EventProcessed = "PROCESSED"
+	EventStale     = "STALE"
+	EventRejected  = "REJECTED"
+	EventFailed    = "FAILED"
+)
+
+var ErrEventNotReprocessable = errors.New("event was rejected and cannot be reprocessed")
+
+// paymentStatusRank orders payment statuses so that an event arriving late
+// cannot move a payment backwards, e.g. PENDING after PAID. A payment may
+// still be paid after a failed attempt or after it expired.
+var paymentStatusRank = map[string]int{
+	PaymentInit:              0,
+	PaymentPending:           1,
+	PaymentFailed:            2,
+	PaymentExpired:           2,
+	PaymentCanceled:          2,
+	PaymentPaid:              3,
+	PaymentPartiallyRefunded: 4,
+	PaymentRefunded:          5,
+}
+
+func paymentStatusAdvances(current, next string) bool {
+	nextRank, ok := paymentStatusRank[next]
+	if !ok {
+		return false
+	}
+	return nextRank > paymentStatusRank[current]
+}
+
+// Headers that are never needed to verify a webhook and must not be stored.
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// HandleWebhook stores the request before acting on it. Requests with a bad
+// signature or an unreadable body are kept as REJECTED for investigation, up
+// to RejectedLimit per provider; for a bad signature only the body hash and
+// size are kept. Redeliveries of an already handled event only bump its
+// duplicate count; applying an event twice is harmless since payment
+// statuses only advance and a refund is booked once per event.
+func (s *PaymentService) HandleWebhook(ctx context.Context, webhook PaymentWebhook) error {
+	provider, err := s.Providers.Get(webhook.Provider)
+	if err != nil {
+		return err
+	}
+
+	header := webhook.Header.Clone()
+	for _, name := range secretHeaders {
+		header.Del(name)
+	}
+	headersRaw, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	id, err := NewID()
+	if err != nil {
+		return err
+	}
+	stored := repositories.PaymentEvent{
+		ID:         id,
+		Provider:   webhook.Provider,
+		HeadersRaw: headersRaw,
+		Body:       webhook.Body,
+		Outcome:    EventReceived,
+		ReceivedAt: time.Now(),
+	}
+
+	var event payments.Event
+	rejectErr := provider.VerifyWebhook(webhook.Header, webhook.Body)
+	if rejectErr == nil {
+		stored.SignatureValid = true
+		if event, rejectErr = provider.ParseEvent(webhook.Body); rejectErr != nil {
+			rejectErr = fmt.Errorf("%w: %v", payments.ErrInvalidEvent, rejectErr)
+		}
+	}
+	if rejectErr != nil {
+		stored.Outcome = EventRejected
+		stored.Error = nullString(rejectErr.Error())
+		if !stored.SignatureValid {
+			// Anyone can send these, so nothing they sent is stored as is.
+			stored.HeadersRaw = []byte(`{}`)
+			stored.Body = []byte{}
+			stored.Error = nullString(fmt.Sprintf("%v; body sha256=%x, %d bytes", rejectErr, sha256.Sum256(webhook.Body), len(webhook.Body)))
+		}
+		if s.storeRejected(ctx, webhook.Provider) {
+			if _, err := s.Events.Create(ctx, stored); err != nil {
+				return err
+			}
+		}
+		return rejectErr
+	}
+	stored.EventID = nullString(event.ID)
+	stored.Status = nullString(event.Status)
+
+	inserted, err := s.Events.Create(ctx, stored)
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		existing, err := s.Events.MarkDuplicate(ctx, webhook.Provider, event.ID)
+		if err != nil {
+			return err
+		}
+		if existing.Outcome != EventFailed && existing.Outcome != EventReceived {
+			return nil
+		}
+		stored = existing
+	}
+	return s.processEvent(ctx, stored.ID, webhook.Provider, event)
+}
+
+func (s *PaymentService) storeRejected(ctx context.Context, provider string) bool {
+	if s.Limiter == nil {
+		return true
+	}
+	decision, err := s.Limiter.Allow(ctx, "webhook_rejected:"+provider, s.RejectedLimit)
+	if err != nil {
+		// Storing is only for investigation; a broken limiter must not let
+		// the table grow unbounded either.
+		return false
+	}
+	return decision.Allowed
+}
+
+// ReprocessEvent applies a stored event again, e.g. after the payment it
+// refers to has been created or a bug fixed. The status ordering still
+// applies, so a stale event stays without effect.
+func (s *PaymentService) ReprocessEvent(ctx context.Context, id string) (repositories.PaymentEvent, error) {
+	stored, err := s.Events.Get(ctx, id)
+	if err != nil {
+		return repositories.PaymentEvent{}, err
+	}
+	// The signature was checked on arrival; it is not checked again since the
+	// provider secret may have been rotated meanwhile.
+	if !stored.SignatureValid || stored.Outcome == EventRejected {
+		return repositories.PaymentEvent{}, ErrEventNotReprocessable
+	}
+	provider, err := s.Providers.Get(stored.Provider)
+	if err != nil {
+		return repositories.PaymentEvent{}, err
+	}
+	event, err := provider.ParseEvent(stored.Body)
+	if err != nil {
+		return repositories.PaymentEvent{}, err
+	}
+
+	processErr := s.processEvent(ctx, stored.ID, stored.Provider, event)
+	stored, err = s.Events.Get(ctx, id)
+	if err != nil {
+		return repositories.PaymentEvent{}, err
+	}
+	return stored, processErr
+}
+
+func (s *PaymentService) processEvent(ctx context.Context, eventRowID, providerName string, event payments.Event) error {
+	outcome, paymentID, err := s.applyEvent(ctx, eventRowID, providerName, event)
+	var errText sql.NullString
+	if err != nil {
+		errText = nullString(err.Error())
+	}
+	if finishErr := s.Events.Finish(ctx, eventRowID, paymentID, outcome, errText, time.Now()); finishErr != nil {
+		return finishErr
+	}
+	return err
+}
+
+func (s *PaymentService) applyEvent(ctx context.Context, eventRowID, providerName string, event payments.Event) (string, sql.NullString, error) {
+	payment, err := s.Payments.FindByProviderID(ctx, providerName, event.ProviderPaymentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return EventFailed, sql.NullString{}, ErrPaymentUnknown
+	}
+	if err != nil {
+		return EventFailed, sql.NullString{}, err
+	}
+	paymentID := sql.NullString{String: payment.ID, Valid: true}
+
+	if event.Status == PaymentPaid && event.AmountCents != 0 && event.AmountCents != payment.AmountCents {
+		return EventFailed, paymentID, ErrPaymentAmount
+	}
+	if event.Status == PaymentRefunded {
+		err = s.applyRefundEvent(ctx, eventRowID, payment.ID, event.RefundID, event.AmountCents)
+	} else {
+		err = s.updatePaymentAndEntity(ctx, payment, event.Status, event.Payload)
+	}
+	if errors.Is(err, ErrPaymentStale) {
+		return EventStale, paymentID, nil
+	}
+	if err != nil {
+		return EventFailed, paymentID, err
+	}
+	return EventProcessed, paymentID, nil
+}
+
+// applyRefundEvent books a refund made in the provider's dashboard the same
+// way as one made through the admin API, without calling the provider. The
+// amount defaults to the remaining balance. Refunds made through the admin
+// API are recorded when they are made and are recognised by the provider's
+// refund id, so the provider's notification about them changes nothing.
+func (s *PaymentService) applyRefundEvent(ctx context.Context, eventRowID, paymentID, providerRefundID string, amountCents int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var payment repositories.Payment
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, type, entity_id, status, amount_cents, refunded_cents, refund_required FROM payments WHERE id = $1 FOR UPDATE
+	`, paymentID).Scan(&payment.ID, &payment.Type, &payment.EntityID, &payment.Status, &payment.AmountCents, &payment.RefundedCents, &payment.RefundRequired)
+	if err != nil {
+		return err
+	}
+	if payment.Status != PaymentPaid && payment.Status != PaymentPartiallyRefunded {
+		err = ErrPaymentStale
+		return err
+	}
+	if providerRefundID != "" {
+		var known bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM payment_refunds WHERE payment_id = $1 AND provider_refund_id = $2)
+		`, payment.ID, providerRefundID).Scan(&known)
+		if err != nil {
+			return err
+		}
+		if known {
+			err = ErrPaymentStale
+			return err
+		}
+	}
+	// A PENDING admin refund does not know its provider id yet, so this may
+	// be its notification. The event fails and is matched once it is booked.
+	var pending bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM payment_refunds WHERE payment_id = $1 AND status = $2)
+	`, payment.ID, RefundPending).Scan(&pending)
+	if err != nil {
+		return err
+	}
+	if pending {
+		err = ErrRefundInProgress
+		return err
+	}
+
+	refund := repositories.PaymentRefund{
+		PaymentID:   payment.ID,
+		AmountCents: amountCents,
+		Reason:      nullString("refunded at provider"),
+		Status:      PaymentRefunded,
+		EventID:     nullString(eventRowID),
+		CreatedAt:   time.Now(),
+	}
+	refund.ProviderRefundID = nullString(providerRefundID)
+	if refund.AmountCents == 0 {
+		refund.AmountCents = payment.AmountCents - payment.RefundedCents
+	}
+	if refund.ID, err = NewID(); err != nil {
+		return err
+	}
+	// The unique event_id makes reprocessing the same event a no-op.
+	var inserted bool
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO payment_refunds (id, payment_id, amount_cents, reason, provider_refund_id, status, event_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING true
+	`, refund.ID, refund.PaymentID, refund.AmountCents, refund.Reason, refund.ProviderRefundID, refund.Status, refund.EventID, refund.CreatedAt).Scan(&inserted)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrPaymentStale
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = refundTx(ctx, tx, payment, refund); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const OrderCanceled = "CANCELED"
+
+// ExpiredPayment is a payment the sweeper expired. EntityStatus is the
+// order, subscription or plan change status after the sweep.
+type ExpiredPayment struct {
+	PaymentID    string
+	Type         string
+	EntityID     string
+	UserID       string
+	EntityStatus string
+}
+
+// PaymentExpiryService expires checkouts the user abandoned. Renewal
+// payments are left to billing, which has its own grace period.
+type PaymentExpiryService struct {
+	DB        *sql.DB
+	TTL       time.Duration
+	BatchSize int
+}
+
+// Expire marks INIT/PENDING payments older than TTL as EXPIRED. An order
+// still in NEW is canceled; stock is only taken when an order is paid, so
+// there is nothing to put back. A subscription stays PAYMENT_PENDING and the
+// user can start a new payment, since none is in progress any more. A plan
+// change upgrade whose surcharge was not paid is canceled, so it no longer
+// blocks the next change. The user gets a notification for each expired
+// payment. If the provider still reports the payment as paid later, the
+// webhook flags it for a refund instead of reviving the order.
+func (s *PaymentExpiryService) Expire(ctx context.Context, now time.Time) ([]ExpiredPayment, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, type, entity_id FROM payments
+		WHERE status IN ('INIT', 'PENDING')
+			AND type IN ('order', 'subscription', 'plan_change')
+			AND period_start IS NULL
+			AND created_at < $1
+		ORDER BY created_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, now.Add(-s.TTL), s.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []ExpiredPayment
+	for rows.Next() {
+		var item ExpiredPayment
+		if err = rows.Scan(&item.PaymentID, &item.Type, &item.EntityID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range expired {
+		item := &expired[i]
+		if _, err = tx.ExecContext(ctx, `UPDATE payments SET status = $2 WHERE id = $1`, item.PaymentID, PaymentExpired); err != nil {
+			return nil, err
+		}
+
+		query := `SELECT user_id, status FROM subscriptions WHERE id = $1`
+		switch item.Type {
+		case "order":
+			if _, err = tx.ExecContext(ctx, `UPDATE orders SET status = $2 WHERE id = $1 AND status = 'NEW'`, item.EntityID, OrderCanceled); err != nil {
+				return nil, err
+			}
+			query = `SELECT user_id, status FROM orders WHERE id = $1`
+		case "plan_change":
+			if _, err = tx.ExecContext(ctx, `UPDATE subscription_plan_changes SET status = 'CANCELED' WHERE id = $1 AND status = 'PENDING'`, item.EntityID); err != nil {
+				return nil, err
+			}
+			query = `
+				SELECT s.user_id, c.status FROM subscription_plan_changes c JOIN subscriptions s ON s.id = c.subscription_id
+				WHERE c.id = $1`
+		}
+		if err = tx.QueryRowContext(ctx, query, item.EntityID).Scan(&item.UserID, &item.EntityStatus); err != nil {
+			return nil, err
+		}
+		err = notifyTx(ctx, tx, item.UserID, NotificationPaymentExpired, item.Type, item.EntityID, map[string]string{
+			"payment_id":    item.PaymentID,
+			"entity_status": item.EntityStatus,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+func (s *PaymentExpiryService) Run(ctx context.Context) error {
+	_, err := s.Expire(ctx, time.Now())
+	return err
+}
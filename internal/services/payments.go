@@ -5,69 +5,319 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"net/http"
+	"sort"
 	"time"
 
+	"nesta/internal/frequency"
+	"nesta/internal/payments"
+	"nesta/internal/ratelimit"
 	"nesta/internal/repositories"
 )
 
+const (
+	PaymentInit     = "INIT"
+	PaymentPending  = "PENDING"
+	PaymentPaid     = "PAID"
+	PaymentFailed   = "FAILED"
+	PaymentExpired  = "EXPIRED"
+	PaymentCanceled = "CANCELED"
+)
+
+var (
+	ErrPaymentType       = errors.New("unsupported payment type")
+	ErrPaymentNotFound   = errors.New("payment entity not found")
+	ErrPaymentNotPayable = errors.New("entity is not awaiting payment")
+	ErrPaymentInProgress = errors.New("payment already in progress")
+	ErrPaymentAmount     = errors.New("payment amount mismatch")
+	ErrPaymentUnknown    = errors.New("payment not found")
+	ErrPaymentStale      = errors.New("payment already has this or a later status")
+	ErrPaymentNotManual  = errors.New("only open manual payments can be confirmed")
+)
+
 type PaymentService struct {
 	DB            *sql.DB
 	Payments      *repositories.PaymentRepository
+	Events        *repositories.PaymentEventRepository
+	Refunds       *repositories.PaymentRefundRepository
 	Orders        *repositories.OrderRepository
 	Subscriptions *repositories.SubscriptionRepository
+	Providers     *payments.Registry
+	Provider      string
+	Currency      string
+	// Limiter caps how many rejected webhooks per provider are stored, so
+	// forged requests cannot fill the table.
+	Limiter       ratelimit.Limiter
+	RejectedLimit ratelimit.Rule
 }
 
+// PaymentInitRequest is what a client may choose; the amount is always
+// worked out on the server.
 type PaymentInitRequest struct {
-	Type              string
-	EntityID          string
-	Provider          string
-	ProviderPaymentID string
-	AmountCents       int
+	UserID   string
+	Type     string
+	EntityID string
+	Provider string
 }
 
+// PaymentCheckout is the created payment plus what the client needs to send
+// the user to the provider.
+type PaymentCheckout struct {
+	Payment  repositories.Payment
+	Checkout payments.Checkout
+}
+
+// PaymentWebhook is a webhook request exactly as received; the body must not
+// be re-encoded before the signature is checked.
 type PaymentWebhook struct {
-	Provider          string
-	ProviderPaymentID string
-	Status            string
-	Payload           any
+	Provider string
+	Header   http.Header
+	Body     []byte
 }
 
-func (s *PaymentService) Init(ctx context.Context, req PaymentInitRequest) (repositories.Payment, error) {
-	id, err := NewID()
+// Init starts a payment for the caller's own order (status NEW) or
+// subscription (status PAYMENT_PENDING). For an ACTIVE or PAST_DUE
+// subscription it pays the open renewal created by billing instead. The
+// entity row is locked so two concurrent calls cannot both pass the
+// in-flight check.
+func (s *PaymentService) Init(ctx context.Context, req PaymentInitRequest) (PaymentCheckout, error) {
+	providerName := req.Provider
+	if providerName == "" {
+		providerName = s.Provider
+	}
+	if _, err := s.Providers.Get(providerName); err != nil {
+		return PaymentCheckout{}, err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return repositories.Payment{}, err
+		return PaymentCheckout{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var ownerID, status, wantStatus string
+	var amount int
+	switch req.Type {
+	case "order":
+		wantStatus = "NEW"
+		err = tx.QueryRowContext(ctx, `
+			SELECT user_id, status, total_cents FROM orders WHERE id = $1 FOR UPDATE
+		`, req.EntityID).Scan(&ownerID, &status, &amount)
+	case "subscription":
+		wantStatus = SubscriptionPaymentPending
+		err = tx.QueryRowContext(ctx, `
+			SELECT s.user_id, s.status, p.price_cents
+			FROM subscriptions s JOIN plans p ON p.id = s.plan_id
+			WHERE s.id = $1
+			FOR UPDATE OF s
+		`, req.EntityID).Scan(&ownerID, &status, &amount)
+	default:
+		err = ErrPaymentType
+		return PaymentCheckout{}, err
 	}
-	payment := repositories.Payment{
-		ID:          id,
-		Type:        req.Type,
-		EntityID:    req.EntityID,
-		Provider:    req.Provider,
-		Status:      "INIT",
-		AmountCents: req.AmountCents,
+	// Someone else's entity is reported as missing so ids cannot be probed.
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && ownerID != req.UserID) {
+		err = ErrPaymentNotFound
+		return PaymentCheckout{}, err
 	}
-	if req.ProviderPaymentID != "" {
-		payment.ProviderPayment = sql.NullString{String: req.ProviderPaymentID, Valid: true}
+	if err != nil {
+		return PaymentCheckout{}, err
 	}
-	if err := s.Payments.Create(ctx, payment); err != nil {
-		return repositories.Payment{}, err
+	if req.Type == "subscription" && (status == SubscriptionActive || status == SubscriptionPastDue) {
+		return s.payRenewal(ctx, tx, req, ownerID)
+	}
+	if status != wantStatus || amount <= 0 {
+		err = ErrPaymentNotPayable
+		return PaymentCheckout{}, err
+	}
+
+	var inFlight bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM payments WHERE type = $1 AND entity_id = $2 AND status IN ($3, $4))
+	`, req.Type, req.EntityID, PaymentInit, PaymentPending).Scan(&inFlight)
+	if err != nil {
+		return PaymentCheckout{}, err
+	}
+	if inFlight {
+		err = ErrPaymentInProgress
+		return PaymentCheckout{}, err
+	}
+
+	var result PaymentCheckout
+	result, err = s.startPaymentTx(ctx, tx, req.Type, req.EntityID, providerName, amount, ownerID)
+	if err != nil {
+		return PaymentCheckout{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return PaymentCheckout{}, err
+	}
+	return result, nil
+}
+
+// startPaymentTx inserts an INIT payment and creates its checkout inside tx.
+// Creating the checkout before commit means a provider error leaves no
+// orphaned INIT payment blocking the next attempt.
+func (s *PaymentService) startPaymentTx(ctx context.Context, tx *sql.Tx, paymentType, entityID, providerName string, amount int, accountID string) (PaymentCheckout, error) {
+	provider, err := s.Providers.Get(providerName)
+	if err != nil {
+		return PaymentCheckout{}, err
 	}
-	return payment, nil
+	payment, err := newPayment(paymentType, entityID, providerName, amount)
+	if err != nil {
+		return PaymentCheckout{}, err
+	}
+	// checkout_at is rolled back with the payment if the checkout fails.
+	payment.CheckoutAt = sql.NullTime{Time: payment.CreatedAt, Valid: true}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO payments (id, type, entity_id, provider, provider_payment_id, status, amount_cents, checkout_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, payment.ID, payment.Type, payment.EntityID, payment.Provider, payment.ProviderPayment, payment.Status, payment.AmountCents, payment.CheckoutAt, payment.CreatedAt)
+	if err != nil {
+		return PaymentCheckout{}, err
+	}
+
+	checkout, err := provider.CreateCheckout(ctx, payments.CheckoutRequest{
+		PaymentID:         payment.ID,
+		ProviderPaymentID: payment.ProviderPayment.String,
+		AmountCents:       payment.AmountCents,
+		Currency:          s.Currency,
+		Description:       paymentDescription(payment),
+		AccountID:         accountID,
+	})
+	if err != nil {
+		return PaymentCheckout{}, err
+	}
+	return PaymentCheckout{Payment: payment, Checkout: checkout}, nil
 }
 
-func (s *PaymentService) HandleWebhook(ctx context.Context, webhook PaymentWebhook) error {
-	payload, _ := json.Marshal(webhook.Payload)
+// payRenewal returns a checkout for the subscription's open renewal payment
+// and commits or rolls back tx. A renewal not yet sent to the provider may
+// switch to the provider the user asked for.
+func (s *PaymentService) payRenewal(ctx context.Context, tx *sql.Tx, req PaymentInitRequest, ownerID string) (result PaymentCheckout, err error) {
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var payment repositories.Payment
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, type, entity_id, provider, provider_payment_id, status, amount_cents, period_start, period_end, created_at
+		FROM payments
+		WHERE type = 'subscription' AND entity_id = $1 AND period_start IS NOT NULL AND status IN ($2, $3)
+		ORDER BY period_start DESC
+		LIMIT 1
+		FOR UPDATE
+	`, req.EntityID, PaymentInit, PaymentPending).Scan(&payment.ID, &payment.Type, &payment.EntityID, &payment.Provider, &payment.ProviderPayment, &payment.Status, &payment.AmountCents, &payment.PeriodStart, &payment.PeriodEnd, &payment.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrPaymentNotPayable
+		return PaymentCheckout{}, err
+	}
+	if err != nil {
+		return PaymentCheckout{}, err
+	}
 
-	existing, err := s.Payments.FindByProviderID(ctx, webhook.Provider, webhook.ProviderPaymentID)
-	if err == nil {
-		if existing.Status == webhook.Status {
-			return nil
+	if req.Provider != "" && req.Provider != payment.Provider {
+		if payment.Status != PaymentInit {
+			err = ErrPaymentInProgress
+			return PaymentCheckout{}, err
+		}
+		payment.Provider = req.Provider
+		if _, err = tx.ExecContext(ctx, `UPDATE payments SET provider = $2 WHERE id = $1`, payment.ID, payment.Provider); err != nil {
+			return PaymentCheckout{}, err
 		}
-		return s.updatePaymentAndEntity(ctx, existing, webhook.Status, payload)
 	}
+	var provider payments.Provider
+	provider, err = s.Providers.Get(payment.Provider)
+	if err != nil {
+		return PaymentCheckout{}, err
+	}
+
+	var checkout payments.Checkout
+	checkout, err = provider.CreateCheckout(ctx, payments.CheckoutRequest{
+		PaymentID:         payment.ID,
+		ProviderPaymentID: payment.ProviderPayment.String,
+		AmountCents:       payment.AmountCents,
+		Currency:          s.Currency,
+		Description:       paymentDescription(payment),
+		AccountID:         ownerID,
+	})
+	if err != nil {
+		return PaymentCheckout{}, err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE payments SET checkout_at = COALESCE(checkout_at, $2) WHERE id = $1`, payment.ID, time.Now()); err != nil {
+		return PaymentCheckout{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return PaymentCheckout{}, err
+	}
+	return PaymentCheckout{Payment: payment, Checkout: checkout}, nil
+}
+
+// newPayment builds an INIT payment. As in billing, the payment id doubles as
+// the merchant reference until the provider assigns its own.
+func newPayment(paymentType, entityID, provider string, amountCents int) (repositories.Payment, error) {
+	id, err := NewID()
+	if err != nil {
+		return repositories.Payment{}, err
+	}
+	return repositories.Payment{
+		ID:              id,
+		Type:            paymentType,
+		EntityID:        entityID,
+		Provider:        provider,
+		ProviderPayment: sql.NullString{String: id, Valid: true},
+		Status:          PaymentInit,
+		AmountCents:     amountCents,
+		CreatedAt:       time.Now(),
+	}, nil
+}
+
+func paymentDescription(payment repositories.Payment) string {
+	switch payment.Type {
+	case "order":
+		return "Nesta: заказ " + payment.EntityID
+	case "subscription":
+		return "Nesta: подписка " + payment.EntityID
+	case "plan_change":
+		return "Nesta: смена тарифа " + payment.EntityID
+	}
+	return "Nesta: оплата " + payment.ID
+}
 
-	return errors.New("payment not found")
+// ConfirmManual marks an INIT or PENDING manual payment as PAID once an
+// admin has received the money, and applies it like a provider webhook would.
+func (s *PaymentService) ConfirmManual(ctx context.Context, id, adminID string) (repositories.Payment, error) {
+	payment, err := s.Payments.Get(ctx, id)
+	if err != nil {
+		return repositories.Payment{}, err
+	}
+	if payment.Provider != (payments.Manual{}).Name() || (payment.Status != PaymentInit && payment.Status != PaymentPending) {
+		return repositories.Payment{}, ErrPaymentNotManual
+	}
+	payload, err := json.Marshal(map[string]any{"confirmed_by": adminID, "confirmed_at": time.Now()})
+	if err != nil {
+		return repositories.Payment{}, err
+	}
+	if err := s.updatePaymentAndEntity(ctx, payment, PaymentPaid, payload); err != nil {
+		if errors.Is(err, ErrPaymentStale) {
+			err = ErrPaymentNotManual
+		}
+		return repositories.Payment{}, err
+	}
+	return s.Payments.Get(ctx, id)
 }
 
+// updatePaymentAndEntity moves the payment to status and applies a PAID or
+// FAILED payment to what it pays for. A payment that arrives when its order
+// or subscription can no longer take it (the order was canceled after the
+// checkout expired, the subscription ended or was already paid for) is kept
+// as PAID but flagged for a refund, and the user is notified. Changes made
+// from an admin route are audited.
 func (s *PaymentService) updatePaymentAndEntity(ctx context.Context, payment repositories.Payment, status string, payload []byte) error {
 	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
@@ -79,43 +329,183 @@ func (s *PaymentService) updatePaymentAndEntity(ctx context.Context, payment rep
 		}
 	}()
 
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM payments WHERE id = $1 FOR UPDATE`, payment.ID).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if !paymentStatusAdvances(current, status) {
+		err = ErrPaymentStale
+		return err
+	}
+	finish, err := auditTx(ctx, tx, "payment", payment.ID, AuditUpdate)
+	if err != nil {
+		return err
+	}
+
 	_, err = tx.ExecContext(ctx, `UPDATE payments SET status = $2, payload_json = $3 WHERE id = $1`, payment.ID, status, payload)
 	if err != nil {
 		return err
 	}
 
-	if payment.Type == "order" && status == "PAID" {
-		_, err = tx.ExecContext(ctx, `UPDATE orders SET status = 'PAID' WHERE id = $1`, payment.EntityID)
-		if err != nil {
+	var refundReason string
+	switch {
+	case payment.Type == "order" && status == PaymentPaid:
+		refundReason, err = s.payOrderTx(ctx, tx, payment.EntityID)
+	case payment.Type == "subscription" && status == PaymentPaid:
+		refundReason, err = s.activateSubscription(ctx, tx, payment)
+	case payment.Type == "subscription" && status == PaymentFailed && payment.PeriodStart.Valid:
+		var current string
+		if err = tx.QueryRowContext(ctx, `SELECT status FROM subscriptions WHERE id = $1 FOR UPDATE`, payment.EntityID).Scan(&current); err != nil {
 			return err
 		}
-
-		items, err := s.Orders.Items(ctx, payment.EntityID)
-		if err != nil {
+		if current == SubscriptionActive {
+			_, err = transitionTx(ctx, tx, payment.EntityID, current, ActionOverdue, "", "renewal payment failed")
+		}
+	case payment.Type == "plan_change" && status == PaymentPaid:
+		var applied bool
+		if applied, err = applyPlanChangeTx(ctx, tx, payment.EntityID); err == nil && !applied {
+			refundReason = "plan change is no longer pending"
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if refundReason != "" {
+		if err = flagRefundTx(ctx, tx, payment, refundReason); err != nil {
 			return err
 		}
-		for _, item := range items {
-			var stock int
-			if err := tx.QueryRowContext(ctx, `SELECT stock FROM products WHERE id = $1 FOR UPDATE`, item.ProductID).Scan(&stock); err != nil {
-				return err
-			}
-			newStock := stock - item.Quantity
-			if newStock < 0 {
-				return errors.New("insufficient stock")
-			}
-			_, err = tx.ExecContext(ctx, `UPDATE products SET stock = $2 WHERE id = $1`, item.ProductID, newStock)
-			if err != nil {
-				return err
+	}
+	if err = finish(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// payOrderTx marks a NEW order paid and takes its items from stock. For an
+// order in any other status it changes nothing and returns why; an order
+// whose items are no longer in stock is canceled so it cannot be paid again.
+// The money has already been taken either way, so neither is an error.
+func (s *PaymentService) payOrderTx(ctx context.Context, tx *sql.Tx, orderID string) (string, error) {
+	var current string
+	if err := tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&current); err != nil {
+		return "", err
+	}
+	if current != "NEW" {
+		return "order is " + current, nil
+	}
+
+	items, err := s.Orders.WithTx(tx).Items(ctx, orderID)
+	if err != nil {
+		return "", err
+	}
+	quantities := map[string]int{}
+	var productIDs []string
+	for _, item := range items {
+		if _, ok := quantities[item.ProductID]; !ok {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		quantities[item.ProductID] += item.Quantity
+	}
+	// Products are locked in a fixed order so concurrent payments cannot
+	// deadlock, and all are checked before any stock is taken.
+	sort.Strings(productIDs)
+	for _, productID := range productIDs {
+		var stock int
+		if err := tx.QueryRowContext(ctx, `SELECT stock FROM products WHERE id = $1 FOR UPDATE`, productID).Scan(&stock); err != nil {
+			return "", err
+		}
+		if stock < quantities[productID] {
+			if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = 'CANCELED' WHERE id = $1`, orderID); err != nil {
+				return "", err
 			}
+			return "product " + productID + " is out of stock", nil
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = 'PAID' WHERE id = $1 AND status = 'NEW'`, orderID); err != nil {
+		return "", err
+	}
+	for _, productID := range productIDs {
+		if _, err := tx.ExecContext(ctx, `UPDATE products SET stock = stock - $2 WHERE id = $1`, productID, quantities[productID]); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// activateSubscription starts or extends the subscription for a paid
+// payment. It changes nothing and returns why when the subscription has
+// ended, when a first payment arrives for an already active subscription,
+// or when a renewal pays for a period that is already covered.
+func (s *PaymentService) activateSubscription(ctx context.Context, tx *sql.Tx, payment repositories.Payment) (string, error) {
+	var current string
+	var currentEnd sql.NullTime
+	err := tx.QueryRowContext(ctx, `
+		SELECT status, current_period_end FROM subscriptions WHERE id = $1 FOR UPDATE
+	`, payment.EntityID).Scan(&current, &currentEnd)
+	if err != nil {
+		return "", err
+	}
+	if !payment.PeriodStart.Valid && current == SubscriptionActive {
+		return "subscription is already active", nil
+	}
+	if payment.PeriodEnd.Valid && currentEnd.Valid && !payment.PeriodEnd.Time.After(currentEnd.Time) {
+		return "period is already paid", nil
+	}
+	if current != SubscriptionActive {
+		if _, err := NextSubscriptionStatus(current, ActionActivate); err != nil {
+			return "subscription is " + current, nil
+		}
+		if _, err := transitionTx(ctx, tx, payment.EntityID, current, ActionActivate, "", "payment "+payment.ID); err != nil {
+			return "", err
 		}
 	}
 
-	if payment.Type == "subscription" && status == "PAID" {
-		_, err = tx.ExecContext(ctx, `UPDATE subscriptions SET status = 'ACTIVE', current_period_start = $2, current_period_end = $3 WHERE id = $1`, payment.EntityID, time.Now(), time.Now().Add(30*24*time.Hour))
+	var periodStart, periodEnd time.Time
+	if payment.PeriodStart.Valid && payment.PeriodEnd.Valid {
+		periodStart = payment.PeriodStart.Time
+		periodEnd = payment.PeriodEnd.Time
+	} else {
+		var rawFrequency string
+		err := tx.QueryRowContext(ctx, `
+			SELECT p.frequency FROM subscriptions s JOIN plans p ON p.id = s.plan_id WHERE s.id = $1
+		`, payment.EntityID).Scan(&rawFrequency)
 		if err != nil {
-			return err
+			return "", err
+		}
+		freq, err := frequency.Parse(rawFrequency)
+		if err != nil {
+			return "", err
 		}
+		periodStart = time.Now()
+		periodEnd = freq.Next(periodStart)
 	}
 
-	return tx.Commit()
+	// A late payment for an older period must not take back days already
+	// granted. A cancellation scheduled while this renewal was already with
+	// the provider moves to the end of the period it pays for.
+	_, err = tx.ExecContext(ctx, `
+		UPDATE subscriptions SET current_period_start = $2, current_period_end = $3,
+			cancel_at = CASE WHEN cancel_at < $3 THEN $3 ELSE cancel_at END
+		WHERE id = $1 AND (current_period_end IS NULL OR current_period_end < $3)
+	`, payment.EntityID, periodStart, periodEnd)
+	return "", err
+}
+
+// flagRefundTx marks a paid payment that could not be applied as needing a
+// refund and tells its owner.
+func flagRefundTx(ctx context.Context, tx *sql.Tx, payment repositories.Payment, reason string) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE payments SET refund_required = $2 WHERE id = $1`, payment.ID, reason); err != nil {
+		return err
+	}
+	userID, err := paymentOwnerTx(ctx, tx, payment.Type, payment.EntityID)
+	if err != nil {
+		return err
+	}
+	return notifyTx(ctx, tx, userID, NotificationRefundRequired, payment.Type, payment.EntityID, map[string]any{
+		"payment_id":   payment.ID,
+		"amount_cents": payment.AmountCents,
+		"reason":       reason,
+	})
 }
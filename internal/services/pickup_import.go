@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"nesta/internal/repositories"
+)
+
+var pickupImportRequired = []string{"subscription_id", "pickup_date", "status"}
+
+type PickupImporter struct {
+	DB *sql.DB
+}
+
+type PickupImportError struct {
+	Line    int
+	Message string
+}
+
+type PickupImportSummary struct {
+	DryRun  bool
+	Total   int
+	Created int
+	Updated int
+	Errors  []PickupImportError
+}
+
+type pickupImportRow struct {
+	subscriptionID string
+	pickupDate     time.Time
+	status         string
+	reason         string
+	comment        string
+}
+
+// Import upserts pickup logs by (subscription_id, pickup_date) from CSV with a
+// header row. All rows are applied in one transaction; any invalid row rolls
+// the whole file back. A dry run validates and counts without committing.
+func (s *PickupImporter) Import(ctx context.Context, input io.Reader, dryRun bool, actorID string) (PickupImportSummary, error) {
+	summary := PickupImportSummary{DryRun: dryRun, Errors: []PickupImportError{}}
+
+	reader := csv.NewReader(input)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return summary, errors.New("csv header required")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range pickupImportRequired {
+		if _, ok := columns[name]; !ok {
+			return summary, fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return summary, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	known := map[string]bool{}
+	for {
+		record, readErr := reader.Read()
+		if readErr == io.EOF {
+			break
+		}
+		summary.Total++
+		if readErr != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(readErr, &parseErr) {
+				return summary, readErr
+			}
+			summary.Errors = append(summary.Errors, PickupImportError{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		row, rowErr := parsePickupImportRow(record, columns)
+		if rowErr == nil {
+			rowErr = checkSubscription(ctx, tx, known, row.subscriptionID)
+		}
+		if rowErr != nil {
+			var validation validationError
+			if !errors.As(rowErr, &validation) {
+				return summary, rowErr
+			}
+			summary.Errors = append(summary.Errors, PickupImportError{Line: line, Message: rowErr.Error()})
+			continue
+		}
+		_, created, err := savePickupTx(ctx, tx, repositories.PickupLog{
+			SubscriptionID: row.subscriptionID,
+			PickupDate:     row.pickupDate,
+			Status:         row.status,
+			Reason:         nullString(row.reason),
+			Comment:        nullString(row.comment),
+		}, true, actorID, PickupSourceImport)
+		if err != nil {
+			return summary, err
+		}
+		if created {
+			summary.Created++
+		} else {
+			summary.Updated++
+		}
+	}
+
+	if dryRun || len(summary.Errors) > 0 {
+		return summary, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+type validationError string
+
+func (e validationError) Error() string {
+	return string(e)
+}
+
+func parsePickupImportRow(record []string, columns map[string]int) (pickupImportRow, error) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	row := pickupImportRow{
+		subscriptionID: field("subscription_id"),
+		status:         strings.ToUpper(field("status")),
+		reason:         strings.ToUpper(field("reason")),
+		comment:        field("comment"),
+	}
+	if row.subscriptionID == "" {
+		return row, validationError("subscription_id required")
+	}
+	date, err := time.Parse("2006-01-02", field("pickup_date"))
+	if err != nil {
+		return row, validationError("invalid pickup_date")
+	}
+	row.pickupDate = date
+
+	switch row.status {
+	case PickupPlanned, PickupDone:
+	case PickupFailed, PickupSkipped:
+		if row.reason == "" {
+			return row, validationError("reason required")
+		}
+	default:
+		return row, validationError("invalid status")
+	}
+	if row.reason != "" && !validPickupReason(row.reason) {
+		return row, validationError("invalid reason")
+	}
+	return row, nil
+}
+
+func checkSubscription(ctx context.Context, tx *sql.Tx, known map[string]bool, id string) error {
+	exists, ok := known[id]
+	if !ok {
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM subscriptions WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return err
+		}
+		known[id] = exists
+	}
+	if !exists {
+		return validationError("subscription not found")
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"nesta/internal/frequency"
+)
+
+const (
+	PickupPlanned = "PLANNED"
+	PickupDone    = "DONE"
+	PickupFailed  = "FAILED"
+
+	AllServiceDays = 127
+)
+
+var serviceDayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseServiceDays converts weekday names (mon..sun) into the bitmask stored
+// on residential_complexes.service_days.
+func ParseServiceDays(days []string) (int, error) {
+	mask := 0
+	for _, day := range days {
+		found := false
+		for i, name := range serviceDayNames {
+			if strings.EqualFold(strings.TrimSpace(day), name) {
+				mask |= 1 << i
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, errors.New("invalid service day")
+		}
+	}
+	if mask == 0 {
+		return 0, errors.New("service days required")
+	}
+	return mask, nil
+}
+
+func ServiceDayNames(mask int) []string {
+	days := []string{}
+	for i, name := range serviceDayNames {
+		if mask&(1<<i) != 0 {
+			days = append(days, name)
+		}
+	}
+	return days
+}
+
+type PickupScheduler struct {
+	DB        *sql.DB
+	Horizon   int
+	BatchSize int
+}
+
+type scheduledSubscription struct {
+	id          string
+	timeWindow  sql.NullString
+	anchor      time.Time
+	cancelAt    sql.NullTime
+	frequency   string
+	serviceDays int
+}
+
+type pauseRange struct {
+	startsOn time.Time
+	endsOn   sql.NullTime
+}
+
+// Run keeps PLANNED pickups in sync for the next Horizon days: missing dates
+// are added for ACTIVE subscriptions and planned dates that no longer apply
+// (pause, cancellation, schedule change) are removed. The cadence counts
+// from the subscription's creation so pickup weekdays stay the same across
+// billing periods; an unpaid renewal ends planning through PAST_DUE.
+func (s *PickupScheduler) Run(ctx context.Context) error {
+	today := dateOf(time.Now())
+	to := today.AddDate(0, 0, s.Horizon)
+
+	_, err := s.DB.ExecContext(ctx, `
+		DELETE FROM pickup_logs p
+		USING subscriptions s
+		WHERE p.subscription_id = s.id AND p.status = 'PLANNED' AND p.pickup_date >= $1 AND s.status <> 'ACTIVE'
+	`, today)
+	if err != nil {
+		return err
+	}
+
+	after := ""
+	for {
+		last, count, err := s.scheduleBatch(ctx, after, today, to)
+		if err != nil {
+			return err
+		}
+		if count < s.BatchSize {
+			return nil
+		}
+		after = last
+	}
+}
+
+func (s *PickupScheduler) scheduleBatch(ctx context.Context, after string, from, to time.Time) (string, int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT s.id, s.time_window, s.created_at, s.cancel_at,
+			p.pickup_frequency, c.service_days
+		FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		JOIN residential_complexes c ON c.id = s.complex_id
+		WHERE s.status = 'ACTIVE' AND s.id > $1
+		ORDER BY s.id
+		LIMIT $2
+		FOR UPDATE OF s SKIP LOCKED
+	`, after, s.BatchSize)
+	if err != nil {
+		return "", 0, err
+	}
+	var subs []scheduledSubscription
+	for rows.Next() {
+		var item scheduledSubscription
+		if err = rows.Scan(&item.id, &item.timeWindow, &item.anchor, &item.cancelAt, &item.frequency, &item.serviceDays); err != nil {
+			rows.Close()
+			return "", 0, err
+		}
+		subs = append(subs, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return "", 0, err
+	}
+
+	for _, sub := range subs {
+		if err = scheduleSubscriptionTx(ctx, tx, sub, from, to); err != nil {
+			return "", 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", 0, err
+	}
+	if len(subs) == 0 {
+		return after, 0, nil
+	}
+	return subs[len(subs)-1].id, len(subs), nil
+}
+
+func scheduleSubscriptionTx(ctx context.Context, tx *sql.Tx, sub scheduledSubscription, from, to time.Time) error {
+	freq, err := frequency.Parse(sub.frequency)
+	if err != nil {
+		return err
+	}
+
+	pauses, err := loadPauses(ctx, tx, sub.id)
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]time.Time{}
+	for _, day := range pickupDates(freq, sub.anchor, from, to, sub.serviceDays) {
+		if sub.cancelAt.Valid && !day.Before(sub.cancelAt.Time) {
+			continue
+		}
+		if paused(pauses, day) {
+			continue
+		}
+		wanted[day.Format("2006-01-02")] = day
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, pickup_date, status FROM pickup_logs
+		WHERE subscription_id = $1 AND pickup_date >= $2 AND pickup_date < $3
+	`, sub.id, from, to)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var (
+			id     string
+			date   time.Time
+			status string
+		)
+		if err = rows.Scan(&id, &date, &status); err != nil {
+			rows.Close()
+			return err
+		}
+		key := date.Format("2006-01-02")
+		if _, ok := wanted[key]; !ok && status == PickupPlanned {
+			stale = append(stale, id)
+		}
+		delete(wanted, key)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range stale {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM pickup_logs WHERE id = $1`, id); err != nil {
+			return err
+		}
+	}
+	for _, day := range wanted {
+		id, err := NewID()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO pickup_logs (id, subscription_id, pickup_date, status, time_window)
+			VALUES ($1, $2, $3, 'PLANNED', $4)
+			ON CONFLICT (subscription_id, pickup_date) DO NOTHING
+		`, id, sub.id, day, sub.timeWindow)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadPauses(ctx context.Context, tx *sql.Tx, subscriptionID string) ([]pauseRange, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT starts_on, ends_on FROM subscription_pauses
+		WHERE subscription_id = $1 AND status IN ('SCHEDULED', 'ACTIVE')
+	`, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pauses []pauseRange
+	for rows.Next() {
+		var item pauseRange
+		if err := rows.Scan(&item.startsOn, &item.endsOn); err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, item)
+	}
+	return pauses, rows.Err()
+}
+
+func paused(pauses []pauseRange, day time.Time) bool {
+	for _, pause := range pauses {
+		if day.Before(dateOf(pause.startsOn)) {
+			continue
+		}
+		if !pause.endsOn.Valid || day.Before(dateOf(pause.endsOn.Time)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pickupDates walks the plan cadence from anchor and returns the dates that
+// fall into [from, to). A date that lands on a day the complex is not served
+// moves to the next service day.
+func pickupDates(freq frequency.Frequency, anchor, from, to time.Time, serviceDays int) []time.Time {
+	if serviceDays&AllServiceDays == 0 {
+		return nil
+	}
+
+	var dates []time.Time
+	seen := map[time.Time]bool{}
+	for candidate := dateOf(anchor); candidate.Before(to); candidate = freq.Next(candidate) {
+		day := candidate
+		for serviceDays&(1<<int(day.Weekday())) == 0 {
+			day = day.AddDate(0, 0, 1)
+		}
+		if day.Before(from) || !day.Before(to) || seen[day] {
+			continue
+		}
+		seen[day] = true
+		dates = append(dates, day)
+	}
+	return dates
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"nesta/internal/frequency"
+)
+
+func TestPickupDates(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2026, time.October, d, 0, 0, 0, 0, time.UTC)
+	}
+	weekly := frequency.Frequency{Kind: frequency.Weekly}
+	daily := frequency.Frequency{Kind: frequency.Days, Days: 1}
+	weekdays := 0b0111110
+	wednesday := 1 << int(time.Wednesday)
+	monday := 1 << int(time.Monday)
+
+	tests := []struct {
+		name        string
+		freq        frequency.Frequency
+		anchor      time.Time
+		from, to    time.Time
+		serviceDays int
+		want        []time.Time
+	}{
+		{name: "weekly on every day", freq: weekly, anchor: day(5), from: day(5), to: day(26), serviceDays: AllServiceDays, want: []time.Time{day(5), day(12), day(19)}},
+		{name: "moves to the next service day", freq: weekly, anchor: day(5), from: day(5), to: day(26), serviceDays: wednesday, want: []time.Time{day(7), day(14), day(21)}},
+		{name: "anchor before the window", freq: weekly, anchor: time.Date(2026, time.September, 7, 15, 0, 0, 0, time.UTC), from: day(1), to: day(15), serviceDays: AllServiceDays, want: []time.Time{day(5), day(12)}},
+		{name: "weekend collapses into monday", freq: daily, anchor: day(9), from: day(9), to: day(13), serviceDays: weekdays, want: []time.Time{day(9), day(12)}},
+		{name: "shifted past the window", freq: weekly, anchor: day(10), from: day(10), to: day(12), serviceDays: monday},
+		{name: "no service days", freq: weekly, anchor: day(5), from: day(5), to: day(26), serviceDays: 0},
+	}
+	for _, tt := range tests {
+		got := pickupDates(tt.freq, tt.anchor, tt.from, tt.to, tt.serviceDays)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: pickupDates = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(tt.want[i]) {
+				t.Errorf("%s: pickupDates = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"nesta/internal/repositories"
+)
+
+const (
+	PickupSourceAdmin   = "admin"
+	PickupSourceCourier = "courier"
+	PickupSourceImport  = "import"
+)
+
+var ErrPickupDuplicate = errors.New("pickup log already exists")
+
+type PickupService struct {
+	DB *sql.DB
+}
+
+// Record stores the outcome for (subscription_id, pickup_date). A PLANNED log
+// is always filled in; any other existing log is replaced only with overwrite.
+func (s *PickupService) Record(ctx context.Context, log repositories.PickupLog, overwrite bool, actorID string) (repositories.PickupLog, bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	saved, created, err := savePickupTx(ctx, tx, log, overwrite, actorID, PickupSourceAdmin)
+	if err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	return saved, created, nil
+}
+
+func (s *PickupService) Update(ctx context.Context, log repositories.PickupLog, actorID string) (repositories.PickupLog, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.PickupLog{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var from string
+	err = tx.QueryRowContext(ctx, `
+		SELECT subscription_id, pickup_date, status, time_window FROM pickup_logs WHERE id = $1 FOR UPDATE
+	`, log.ID).Scan(&log.SubscriptionID, &log.PickupDate, &from, &log.TimeWindow)
+	if err != nil {
+		return repositories.PickupLog{}, err
+	}
+	finish, err := auditTx(ctx, tx, "pickup_log", log.ID, AuditUpdate)
+	if err != nil {
+		return repositories.PickupLog{}, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE pickup_logs SET status = $2, comment = $3, reason = $4 WHERE id = $1
+	`, log.ID, log.Status, log.Comment, log.Reason)
+	if err != nil {
+		return repositories.PickupLog{}, err
+	}
+	if err = insertPickupHistory(ctx, tx, log, from, actorID, PickupSourceAdmin); err != nil {
+		return repositories.PickupLog{}, err
+	}
+	if err = finish(); err != nil {
+		return repositories.PickupLog{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return repositories.PickupLog{}, err
+	}
+	return log, nil
+}
+
+// savePickupTx inserts the log or updates the one already recorded for the
+// same subscription and date, appending a history row either way.
+func savePickupTx(ctx context.Context, tx *sql.Tx, log repositories.PickupLog, overwrite bool, actorID, source string) (repositories.PickupLog, bool, error) {
+	if log.ID == "" {
+		id, err := NewID()
+		if err != nil {
+			return repositories.PickupLog{}, false, err
+		}
+		log.ID = id
+	}
+
+	finishCreate, err := auditTx(ctx, tx, "pickup_log", log.ID, AuditCreate)
+	if err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO pickup_logs (id, subscription_id, pickup_date, status, comment, reason, time_window, photo_ref, courier_id, completed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (subscription_id, pickup_date) DO NOTHING
+	`, log.ID, log.SubscriptionID, log.PickupDate, log.Status, log.Comment, log.Reason, log.TimeWindow, log.PhotoRef, log.CourierID, log.CompletedAt)
+	if err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	if inserted > 0 {
+		if err := finishCreate(); err != nil {
+			return repositories.PickupLog{}, false, err
+		}
+		return log, true, insertPickupHistory(ctx, tx, log, "", actorID, source)
+	}
+
+	var (
+		from       string
+		timeWindow sql.NullString
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, status, time_window FROM pickup_logs
+		WHERE subscription_id = $1 AND pickup_date = $2
+		FOR UPDATE
+	`, log.SubscriptionID, log.PickupDate).Scan(&log.ID, &from, &timeWindow)
+	if err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	if from != PickupPlanned && !overwrite {
+		return repositories.PickupLog{}, false, ErrPickupDuplicate
+	}
+	finishUpdate, err := auditTx(ctx, tx, "pickup_log", log.ID, AuditUpdate)
+	if err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	if !log.TimeWindow.Valid {
+		log.TimeWindow = timeWindow
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE pickup_logs
+		SET status = $2, comment = $3, reason = $4, time_window = $5, photo_ref = $6, courier_id = $7, completed_at = $8
+		WHERE id = $1
+	`, log.ID, log.Status, log.Comment, log.Reason, log.TimeWindow, log.PhotoRef, log.CourierID, log.CompletedAt)
+	if err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	if err := finishUpdate(); err != nil {
+		return repositories.PickupLog{}, false, err
+	}
+	return log, false, insertPickupHistory(ctx, tx, log, from, actorID, source)
+}
+
+func insertPickupHistory(ctx context.Context, tx *sql.Tx, log repositories.PickupLog, from, actorID, source string) error {
+	id, err := NewID()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO pickup_log_history (id, pickup_log_id, from_status, to_status, reason, comment, actor_id, source, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, id, log.ID, nullString(from), log.Status, log.Reason, log.Comment, nullString(actorID), source, time.Now())
+	return err
+}
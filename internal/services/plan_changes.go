@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"nesta/internal/frequency"
+	"nesta/internal/payments"
+	"nesta/internal/repositories"
+)
+
+var (
+	ErrPlanChangePending   = errors.New("plan change already pending")
+	ErrNoPendingPlanChange = errors.New("no pending plan change")
+)
+
+const (
+	PlanChangeUpgrade   = "UPGRADE"
+	PlanChangeDowngrade = "DOWNGRADE"
+
+	PlanChangePending  = "PENDING"
+	PlanChangeApplied  = "APPLIED"
+	PlanChangeCanceled = "CANCELED"
+)
+
+type PlanChangeResult struct {
+	Subscription repositories.Subscription
+	Change       repositories.PlanChange
+	Payment      *repositories.Payment
+	Checkout     *payments.Checkout
+}
+
+// ChangePlan switches an ACTIVE subscription to another plan. Upgrades take
+// effect once the prorated difference is paid; downgrades wait for the next
+// period boundary, so no credit is issued for the current period.
+func (s *SubscriptionService) ChangePlan(ctx context.Context, id, planID, provider string) (PlanChangeResult, error) {
+	sub, err := s.Subscriptions.Get(ctx, id)
+	if err != nil {
+		return PlanChangeResult{}, err
+	}
+	if _, err := NextSubscriptionStatus(sub.Status, ActionChangePlan); err != nil {
+		return PlanChangeResult{}, err
+	}
+	if sub.PlanID == planID {
+		return PlanChangeResult{}, errors.New("subscription already on this plan")
+	}
+
+	newPlan, err := s.Plans.Get(ctx, planID)
+	if err != nil {
+		return PlanChangeResult{}, err
+	}
+	if !newPlan.IsActive {
+		return PlanChangeResult{}, errors.New("plan not active")
+	}
+	oldPlan, err := s.Plans.Get(ctx, sub.PlanID)
+	if err != nil {
+		return PlanChangeResult{}, err
+	}
+
+	changeID, err := NewID()
+	if err != nil {
+		return PlanChangeResult{}, err
+	}
+	now := time.Now()
+	change := repositories.PlanChange{
+		ID:             changeID,
+		SubscriptionID: id,
+		FromPlanID:     oldPlan.ID,
+		ToPlanID:       newPlan.ID,
+		Kind:           PlanChangeUpgrade,
+		Status:         PlanChangePending,
+		EffectiveAt:    now,
+		CreatedAt:      now,
+	}
+
+	// Nothing has been paid yet, so the plan is simply swapped.
+	if sub.Status == SubscriptionPaymentPending || !sub.CurrentPeriodStart.Valid || !sub.CurrentPeriodEnd.Valid {
+		return s.createPlanChange(ctx, sub, change, true, "")
+	}
+
+	charge, err := prorate(oldPlan, newPlan, sub.CurrentPeriodStart.Time, sub.CurrentPeriodEnd.Time, now)
+	if err != nil {
+		return PlanChangeResult{}, err
+	}
+
+	if charge < 0 || (charge == 0 && newPlan.PriceCents < oldPlan.PriceCents) {
+		change.Kind = PlanChangeDowngrade
+		change.EffectiveAt = sub.CurrentPeriodEnd.Time
+		return s.createPlanChange(ctx, sub, change, false, "")
+	}
+	if charge == 0 {
+		return s.createPlanChange(ctx, sub, change, true, "")
+	}
+
+	if provider == "" {
+		provider = s.PaymentProvider
+	}
+	change.AmountCents = charge
+	return s.createPlanChange(ctx, sub, change, false, provider)
+}
+
+// createPlanChange stores the change and either applies it at once or, for
+// an upgrade with a surcharge, starts its payment through provider in the
+// same transaction, so the client gets a checkout or nothing is stored. The
+// subscription row is locked so that concurrent requests cannot both store
+// a pending change, or one based on a plan that has changed since sub was
+// read.
+func (s *SubscriptionService) createPlanChange(ctx context.Context, sub repositories.Subscription, change repositories.PlanChange, apply bool, provider string) (PlanChangeResult, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return PlanChangeResult{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var planID string
+	if err = tx.QueryRowContext(ctx, `SELECT plan_id FROM subscriptions WHERE id = $1 FOR UPDATE`, sub.ID).Scan(&planID); err != nil {
+		return PlanChangeResult{}, err
+	}
+	if planID != change.FromPlanID {
+		err = ErrPlanChangePending
+		return PlanChangeResult{}, err
+	}
+	var pending bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM subscription_plan_changes WHERE subscription_id = $1 AND status = $2)
+	`, sub.ID, PlanChangePending).Scan(&pending)
+	if err != nil {
+		return PlanChangeResult{}, err
+	}
+	if pending {
+		err = ErrPlanChangePending
+		return PlanChangeResult{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO subscription_plan_changes (id, subscription_id, from_plan_id, to_plan_id, kind, amount_cents, status, effective_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, change.ID, change.SubscriptionID, change.FromPlanID, change.ToPlanID, change.Kind, change.AmountCents, change.Status, change.EffectiveAt, change.CreatedAt)
+	if err != nil {
+		return PlanChangeResult{}, err
+	}
+
+	result := PlanChangeResult{Subscription: sub, Change: change}
+	if apply {
+		if _, err = applyPlanChangeTx(ctx, tx, change.ID); err != nil {
+			return PlanChangeResult{}, err
+		}
+		result.Change.Status = PlanChangeApplied
+		result.Change.AppliedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		result.Subscription.PlanID = change.ToPlanID
+	} else if change.AmountCents > 0 {
+		var started PaymentCheckout
+		started, err = s.Payments.startPaymentTx(ctx, tx, "plan_change", change.ID, provider, change.AmountCents, sub.UserID)
+		if err != nil {
+			return PlanChangeResult{}, err
+		}
+		result.Payment = &started.Payment
+		result.Checkout = &started.Checkout
+	}
+
+	if err = tx.Commit(); err != nil {
+		return PlanChangeResult{}, err
+	}
+	return result, nil
+}
+
+// CancelPlanChange withdraws the subscription's pending plan change. An
+// upgrade's unpaid surcharge is canceled with it.
+func (s *SubscriptionService) CancelPlanChange(ctx context.Context, id string) (repositories.PlanChange, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.PlanChange{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var change repositories.PlanChange
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, subscription_id, from_plan_id, to_plan_id, kind, amount_cents, status, effective_at, created_at
+		FROM subscription_plan_changes
+		WHERE subscription_id = $1 AND status = 'PENDING'
+		FOR UPDATE
+	`, id).Scan(&change.ID, &change.SubscriptionID, &change.FromPlanID, &change.ToPlanID, &change.Kind, &change.AmountCents, &change.Status, &change.EffectiveAt, &change.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNoPendingPlanChange
+		return repositories.PlanChange{}, err
+	}
+	if err != nil {
+		return repositories.PlanChange{}, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE subscription_plan_changes SET status = 'CANCELED' WHERE id = $1`, change.ID); err != nil {
+		return repositories.PlanChange{}, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE payments SET status = $2 WHERE type = 'plan_change' AND entity_id = $1 AND status IN ('INIT', 'PENDING')
+	`, change.ID, PaymentCanceled)
+	if err != nil {
+		return repositories.PlanChange{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return repositories.PlanChange{}, err
+	}
+	change.Status = PlanChangeCanceled
+	return change, nil
+}
+
+// applyPlanChangeTx moves the subscription to the target plan unless the
+// change is no longer pending or the subscription has ended meanwhile, and
+// reports whether it did.
+func applyPlanChangeTx(ctx context.Context, tx *sql.Tx, changeID string) (bool, error) {
+	var subscriptionID, toPlanID, status, subscriptionStatus string
+	err := tx.QueryRowContext(ctx, `
+		SELECT c.subscription_id, c.to_plan_id, c.status, s.status
+		FROM subscription_plan_changes c
+		JOIN subscriptions s ON s.id = c.subscription_id
+		WHERE c.id = $1
+		FOR UPDATE OF c, s
+	`, changeID).Scan(&subscriptionID, &toPlanID, &status, &subscriptionStatus)
+	if err != nil {
+		return false, err
+	}
+	if status != PlanChangePending {
+		return false, nil
+	}
+
+	if subscriptionStatus == SubscriptionCanceled || subscriptionStatus == SubscriptionExpired {
+		_, err = tx.ExecContext(ctx, `UPDATE subscription_plan_changes SET status = 'CANCELED' WHERE id = $1`, changeID)
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE subscriptions SET plan_id = $2 WHERE id = $1`, subscriptionID, toPlanID); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE subscription_plan_changes SET status = 'APPLIED', applied_at = $2 WHERE id = $1
+	`, changeID, time.Now())
+	return err == nil, err
+}
+
+// prorate returns what the remaining part of the period costs on the new plan
+// minus what it was worth on the old one. Each plan is priced over its own
+// period length so plans with different frequencies compare fairly.
+func prorate(oldPlan, newPlan repositories.Plan, periodStart, periodEnd, now time.Time) (int, error) {
+	remaining := periodEnd.Sub(now)
+	oldLength := periodEnd.Sub(periodStart)
+	if remaining <= 0 || oldLength <= 0 {
+		return 0, nil
+	}
+	if remaining > oldLength {
+		remaining = oldLength
+	}
+
+	newFrequency, err := frequency.Parse(newPlan.Frequency)
+	if err != nil {
+		return 0, err
+	}
+	newLength := newFrequency.Next(periodStart).Sub(periodStart)
+
+	oldShare := float64(oldPlan.PriceCents) * remaining.Seconds() / oldLength.Seconds()
+	newShare := float64(newPlan.PriceCents) * remaining.Seconds() / newLength.Seconds()
+	return int(math.Round(newShare - oldShare)), nil
+}
+
+func (s *BillingService) ApplyPlanChanges(ctx context.Context, now time.Time) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM subscription_plan_changes
+		WHERE status = 'PENDING' AND kind = 'DOWNGRADE' AND effective_at <= $1
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, now, s.BatchSize)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err = applyPlanChangeTx(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"nesta/internal/repositories"
+)
+
+func TestProrate(t *testing.T) {
+	monthly := func(price int) repositories.Plan {
+		return repositories.Plan{PriceCents: price, Frequency: "monthly"}
+	}
+	// A 31 day period, so monthly plans cost the same per second on both sides.
+	start := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+	half := start.Add(end.Sub(start) / 2)
+
+	tests := []struct {
+		name    string
+		oldPlan repositories.Plan
+		newPlan repositories.Plan
+		now     time.Time
+		want    int
+		wantErr bool
+	}{
+		{name: "upgrade at half period", oldPlan: monthly(1000), newPlan: monthly(2000), now: half, want: 500},
+		{name: "downgrade at half period", oldPlan: monthly(2000), newPlan: monthly(1000), now: half, want: -500},
+		{name: "same price", oldPlan: monthly(1000), newPlan: monthly(1000), now: half, want: 0},
+		{name: "before the period counts it whole", oldPlan: monthly(1000), newPlan: monthly(2000), now: start.Add(-time.Hour), want: 1000},
+		{name: "after the period", oldPlan: monthly(1000), newPlan: monthly(2000), now: end, want: 0},
+		{
+			name:    "weekly plan priced over its own week",
+			oldPlan: monthly(3100),
+			newPlan: repositories.Plan{PriceCents: 1000, Frequency: "weekly"},
+			now:     end.AddDate(0, 0, -7),
+			want:    300,
+		},
+		{name: "invalid new frequency", oldPlan: monthly(1000), newPlan: repositories.Plan{PriceCents: 2000, Frequency: "daily"}, now: half, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := prorate(tt.oldPlan, tt.newPlan, start, end, tt.now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: prorate = %d, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: prorate = %d, %v; want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"nesta/internal/payments"
+	"nesta/internal/repositories"
+)
+
+// Discrepancy kinds found by Reconcile.
+const (
+	DiscrepancyMissingLocally    = "MISSING_LOCALLY"
+	DiscrepancyMissingAtProvider = "MISSING_AT_PROVIDER"
+	DiscrepancyAmount            = "AMOUNT_MISMATCH"
+	DiscrepancyStatus            = "STATUS_MISMATCH"
+)
+
+type Discrepancy struct {
+	Kind                string `json:"kind"`
+	ProviderPaymentID   string `json:"provider_payment_id"`
+	PaymentID           string `json:"payment_id,omitempty"`
+	LocalStatus         string `json:"local_status,omitempty"`
+	ProviderStatus      string `json:"provider_status,omitempty"`
+	LocalAmountCents    int    `json:"local_amount_cents,omitempty"`
+	ProviderAmountCents int    `json:"provider_amount_cents,omitempty"`
+	Fixed               bool   `json:"fixed"`
+	Error               string `json:"error,omitempty"`
+}
+
+// ReconcileRequest compares the provider's payments created in [From, To)
+// with Settlements from a report, or, when Settlements is nil, with what
+// Finder reports for each of them.
+type ReconcileRequest struct {
+	Provider    string
+	From        time.Time
+	To          time.Time
+	Settlements []payments.Settlement
+	Finder      payments.Finder
+	Fix         bool
+}
+
+type ReconcileReport struct {
+	Provider      string        `json:"provider"`
+	From          time.Time     `json:"from"`
+	To            time.Time     `json:"to"`
+	Checked       int           `json:"checked"`
+	Matched       int           `json:"matched"`
+	Skipped       int           `json:"skipped"`
+	Fixed         int           `json:"fixed"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// Reconcile reports every payment whose local state disagrees with the
+// provider. With Fix, a status the provider is ahead on is applied through
+// the same path as a webhook, but only when the amounts agree and the status
+// is PENDING, PAID or FAILED; refunds and everything else are left to an
+// admin.
+func (s *PaymentService) Reconcile(ctx context.Context, req ReconcileRequest) (ReconcileReport, error) {
+	report := ReconcileReport{Provider: req.Provider, From: req.From, To: req.To, Discrepancies: []Discrepancy{}}
+
+	local, err := s.Payments.ListByProvider(ctx, req.Provider, req.From, req.To)
+	if err != nil {
+		return report, err
+	}
+
+	if req.Settlements == nil {
+		if req.Finder == nil {
+			return report, payments.ErrNotSupported
+		}
+		for _, payment := range local {
+			if neverSent(payment) {
+				report.Skipped++
+				continue
+			}
+			report.Checked++
+			settlement, err := req.Finder.FindPayment(ctx, payment.ProviderPayment.String)
+			if errors.Is(err, payments.ErrNotFound) {
+				report.Discrepancies = append(report.Discrepancies, missingAtProvider(payment))
+				continue
+			}
+			if err != nil {
+				return report, err
+			}
+			s.reconcilePayment(ctx, &report, payment, settlement, req.Fix)
+		}
+		return report, nil
+	}
+
+	byProviderID := make(map[string]repositories.Payment, len(local))
+	for _, payment := range local {
+		byProviderID[payment.ProviderPayment.String] = payment
+	}
+	for _, settlement := range req.Settlements {
+		report.Checked++
+		payment, ok := byProviderID[settlement.ProviderPaymentID]
+		if ok {
+			delete(byProviderID, settlement.ProviderPaymentID)
+		} else {
+			// The report may cover payments created just outside the range.
+			payment, err = s.Payments.FindByProviderID(ctx, req.Provider, settlement.ProviderPaymentID)
+			if errors.Is(err, sql.ErrNoRows) {
+				report.Discrepancies = append(report.Discrepancies, Discrepancy{
+					Kind:                DiscrepancyMissingLocally,
+					ProviderPaymentID:   settlement.ProviderPaymentID,
+					ProviderStatus:      settlement.Status,
+					ProviderAmountCents: settlement.AmountCents,
+				})
+				continue
+			}
+			if err != nil {
+				return report, err
+			}
+		}
+		s.reconcilePayment(ctx, &report, payment, settlement, req.Fix)
+	}
+	for _, payment := range local {
+		if _, ok := byProviderID[payment.ProviderPayment.String]; ok {
+			if neverSent(payment) {
+				report.Skipped++
+				continue
+			}
+			report.Checked++
+			report.Discrepancies = append(report.Discrepancies, missingAtProvider(payment))
+		}
+	}
+	return report, nil
+}
+
+func (s *PaymentService) reconcilePayment(ctx context.Context, report *ReconcileReport, payment repositories.Payment, settlement payments.Settlement, fix bool) {
+	item := Discrepancy{
+		ProviderPaymentID:   settlement.ProviderPaymentID,
+		PaymentID:           payment.ID,
+		LocalStatus:         payment.Status,
+		ProviderStatus:      settlement.Status,
+		LocalAmountCents:    payment.AmountCents,
+		ProviderAmountCents: settlement.AmountCents,
+	}
+	switch {
+	case payment.AmountCents != settlement.AmountCents:
+		item.Kind = DiscrepancyAmount
+	case payment.Status != settlement.Status:
+		item.Kind = DiscrepancyStatus
+	default:
+		report.Matched++
+		return
+	}
+
+	if fix && item.Kind == DiscrepancyStatus && settlement.Status != payments.StatusRefunded && paymentStatusAdvances(payment.Status, settlement.Status) {
+		payload := settlement.Payload
+		if payload == nil {
+			payload = payment.PayloadRaw
+		}
+		if err := s.updatePaymentAndEntity(ctx, payment, settlement.Status, payload); err != nil {
+			item.Error = err.Error()
+		} else {
+			item.Fixed = true
+			report.Fixed++
+		}
+	}
+	report.Discrepancies = append(report.Discrepancies, item)
+}
+
+// neverSent reports a payment that ended without ever reaching the
+// provider, e.g. a renewal paid for by another payment or one that expired
+// before the user opened the checkout. The provider cannot know about it.
+func neverSent(payment repositories.Payment) bool {
+	switch payment.Status {
+	case PaymentInit, PaymentExpired, PaymentCanceled:
+		return !payment.CheckoutAt.Valid
+	}
+	return false
+}
+
+func missingAtProvider(payment repositories.Payment) Discrepancy {
+	return Discrepancy{
+		Kind:              DiscrepancyMissingAtProvider,
+		ProviderPaymentID: payment.ProviderPayment.String,
+		PaymentID:         payment.ID,
+		LocalStatus:       payment.Status,
+		LocalAmountCents:  payment.AmountCents,
+	}
+}
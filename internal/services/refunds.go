@@ -0,0 +1,385 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"nesta/internal/payments"
+	"nesta/internal/repositories"
+)
+
+// Refund statuses, used for both payments and orders.
+const (
+	PaymentPartiallyRefunded = "PARTIALLY_REFUNDED"
+	PaymentRefunded          = payments.StatusRefunded
+)
+
+// Statuses of a payment_refunds row besides the provider's own.
+const (
+	RefundPending = "PENDING"
+	RefundFailed  = "FAILED"
+)
+
+var (
+	ErrRefundNotAllowed = errors.New("only paid payments can be refunded")
+	ErrRefundAmount     = errors.New("refund amount exceeds the refundable balance")
+	ErrRefundInvalid    = errors.New("invalid refund")
+	ErrRefundProvider   = errors.New("provider refund failed")
+	ErrRefundInProgress = errors.New("another refund of this payment is in progress")
+)
+
+type RefundItem struct {
+	OrderItemID string `json:"order_item_id"`
+	Quantity    int    `json:"quantity"`
+}
+
+// PaymentRefundRequest refunds AmountCents, or when it is zero the value of
+// Items, or when there are no items the whole remaining balance. Items are
+// returned to stock.
+type PaymentRefundRequest struct {
+	PaymentID          string
+	AmountCents        int
+	Items              []RefundItem
+	CancelSubscription bool
+	Reason             string
+	AdminID            string
+}
+
+type PaymentRefundResult struct {
+	Refund        repositories.PaymentRefund
+	PaymentStatus string
+	EntityStatus  string
+}
+
+// Refund pays money back in three steps so that a retry cannot refund twice:
+// the refund is first recorded as PENDING, then the provider is asked to pay
+// it back with the refund id as idempotency key, and only then the payment,
+// order stock or subscription are updated. A request repeated while a refund
+// is PENDING (e.g. after a timeout) resumes that refund with the same id.
+func (s *PaymentService) Refund(ctx context.Context, req PaymentRefundRequest) (PaymentRefundResult, error) {
+	refund, payment, err := s.startRefund(ctx, req)
+	if err != nil {
+		return PaymentRefundResult{}, err
+	}
+	provider, err := s.Providers.Get(payment.Provider)
+	if err != nil {
+		return PaymentRefundResult{}, err
+	}
+
+	providerRefund, err := provider.Refund(ctx, payments.RefundRequest{
+		RefundID:          refund.ID,
+		ProviderPaymentID: payment.ProviderPayment.String,
+		AmountCents:       refund.AmountCents,
+		Currency:          s.Currency,
+		Payload:           payment.PayloadRaw,
+	})
+	if err != nil {
+		// Only a definite refusal frees the payment for another refund; after
+		// any other error the money may have moved, so the refund stays
+		// PENDING until it is retried.
+		if errors.Is(err, payments.ErrDeclined) {
+			_, _ = s.Refunds.Finish(context.WithoutCancel(ctx), refund.ID, RefundPending, RefundFailed, sql.NullString{})
+		}
+		return PaymentRefundResult{}, fmt.Errorf("%w: %v", ErrRefundProvider, err)
+	}
+	refund.ProviderRefundID = nullString(providerRefund.ProviderRefundID)
+	refund.Status = providerRefund.Status
+
+	return s.bookRefund(ctx, refund)
+}
+
+// startRefund validates the request and records it as a PENDING refund, or
+// returns the PENDING refund left by an earlier identical request.
+func (s *PaymentService) startRefund(ctx context.Context, req PaymentRefundRequest) (repositories.PaymentRefund, repositories.Payment, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var payment repositories.Payment
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, type, entity_id, provider, provider_payment_id, status, amount_cents, refunded_cents, payload_json, refund_required
+		FROM payments WHERE id = $1 FOR UPDATE
+	`, req.PaymentID).Scan(&payment.ID, &payment.Type, &payment.EntityID, &payment.Provider, &payment.ProviderPayment, &payment.Status, &payment.AmountCents, &payment.RefundedCents, &payment.PayloadRaw, &payment.RefundRequired)
+	if err != nil {
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+	if payment.Status != PaymentPaid && payment.Status != PaymentPartiallyRefunded {
+		err = ErrRefundNotAllowed
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+	if len(req.Items) > 0 && payment.Type != "order" {
+		err = fmt.Errorf("%w: items can only be returned for order payments", ErrRefundInvalid)
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+	if req.CancelSubscription && payment.Type != "subscription" {
+		err = fmt.Errorf("%w: cancel_subscription only applies to subscription payments", ErrRefundInvalid)
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+	if payment.RefundRequired.Valid && (len(req.Items) > 0 || req.CancelSubscription) {
+		err = fmt.Errorf("%w: the payment was never applied to its %s", ErrRefundInvalid, payment.Type)
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+
+	var itemsValue int
+	itemsValue, err = orderItemsValueTx(ctx, tx, payment.EntityID, req.Items)
+	if err != nil {
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+	remaining := payment.AmountCents - payment.RefundedCents
+	amount := req.AmountCents
+	if amount == 0 {
+		amount = remaining
+		if len(req.Items) > 0 {
+			amount = itemsValue
+		}
+	}
+	if amount <= 0 || amount > remaining {
+		err = ErrRefundAmount
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+
+	refunds := s.Refunds.WithTx(tx)
+	pending, err := refunds.FindByStatus(ctx, payment.ID, RefundPending)
+	switch {
+	case err == nil:
+		if pending.AmountCents != amount || pending.CancelSub != req.CancelSubscription || !sameRefundItems(pending.ItemsRaw, req.Items) {
+			err = ErrRefundInProgress
+			return repositories.PaymentRefund{}, repositories.Payment{}, err
+		}
+		if err = tx.Commit(); err != nil {
+			return repositories.PaymentRefund{}, repositories.Payment{}, err
+		}
+		return pending, payment, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+
+	refund := repositories.PaymentRefund{
+		PaymentID:   payment.ID,
+		AmountCents: amount,
+		CancelSub:   req.CancelSubscription,
+		Reason:      nullString(req.Reason),
+		Status:      RefundPending,
+		AdminID:     nullString(req.AdminID),
+		CreatedAt:   time.Now(),
+	}
+	if refund.ID, err = NewID(); err != nil {
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+	if len(req.Items) > 0 {
+		if refund.ItemsRaw, err = json.Marshal(req.Items); err != nil {
+			return repositories.PaymentRefund{}, repositories.Payment{}, err
+		}
+	}
+	if err = refunds.Create(ctx, refund); err != nil {
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return repositories.PaymentRefund{}, repositories.Payment{}, err
+	}
+	return refund, payment, nil
+}
+
+// bookRefund applies a refund the provider has accepted: the payment, order
+// stock or subscription change and the refund leaves PENDING, in one
+// transaction. If it fails the refund stays PENDING and a retry books it.
+func (s *PaymentService) bookRefund(ctx context.Context, refund repositories.PaymentRefund) (PaymentRefundResult, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return PaymentRefundResult{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var payment repositories.Payment
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, type, entity_id, amount_cents, refunded_cents, refund_required FROM payments WHERE id = $1 FOR UPDATE
+	`, refund.PaymentID).Scan(&payment.ID, &payment.Type, &payment.EntityID, &payment.AmountCents, &payment.RefundedCents, &payment.RefundRequired)
+	if err != nil {
+		return PaymentRefundResult{}, err
+	}
+	var finished bool
+	finished, err = s.Refunds.WithTx(tx).Finish(ctx, refund.ID, RefundPending, refund.Status, refund.ProviderRefundID)
+	if err != nil {
+		return PaymentRefundResult{}, err
+	}
+	if !finished {
+		// A concurrent retry booked it first.
+		err = ErrRefundInProgress
+		return PaymentRefundResult{}, err
+	}
+
+	var result PaymentRefundResult
+	if result, err = refundTx(ctx, tx, payment, refund); err != nil {
+		return PaymentRefundResult{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return PaymentRefundResult{}, err
+	}
+	result.Refund = refund
+	return result, nil
+}
+
+// refundTx does the bookkeeping of refund on the locked payment: returned
+// items go back to stock, the payment and order become (partially) refunded
+// and the subscription is canceled if asked. A payment flagged as needing a
+// refund never reached its order or subscription, so only the payment
+// changes, and the flag is cleared once it is fully refunded.
+func refundTx(ctx context.Context, tx *sql.Tx, payment repositories.Payment, refund repositories.PaymentRefund) (PaymentRefundResult, error) {
+	if payment.RefundedCents+refund.AmountCents > payment.AmountCents {
+		return PaymentRefundResult{}, ErrRefundAmount
+	}
+	var items []RefundItem
+	if len(refund.ItemsRaw) > 0 {
+		if err := json.Unmarshal(refund.ItemsRaw, &items); err != nil {
+			return PaymentRefundResult{}, err
+		}
+	}
+
+	finishPayment, err := auditTx(ctx, tx, "payment", payment.ID, "refund")
+	if err != nil {
+		return PaymentRefundResult{}, err
+	}
+	applied := !payment.RefundRequired.Valid
+	var finishEntity func() error
+	if applied && payment.Type == "order" {
+		if finishEntity, err = auditTx(ctx, tx, "order", payment.EntityID, "refund"); err != nil {
+			return PaymentRefundResult{}, err
+		}
+	}
+
+	if _, err := returnOrderItemsTx(ctx, tx, payment.EntityID, items); err != nil {
+		return PaymentRefundResult{}, err
+	}
+
+	result := PaymentRefundResult{PaymentStatus: PaymentPartiallyRefunded}
+	if payment.RefundedCents+refund.AmountCents == payment.AmountCents {
+		result.PaymentStatus = PaymentRefunded
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE payments SET status = $2, refunded_cents = refunded_cents + $3,
+			refund_required = CASE WHEN $2 = 'REFUNDED' THEN NULL ELSE refund_required END
+		WHERE id = $1
+	`, payment.ID, result.PaymentStatus, refund.AmountCents)
+	if err != nil {
+		return PaymentRefundResult{}, err
+	}
+
+	switch {
+	case !applied:
+		// Only the payment changes.
+	case payment.Type == "order":
+		result.EntityStatus = result.PaymentStatus
+		if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = $2 WHERE id = $1`, payment.EntityID, result.EntityStatus); err != nil {
+			return PaymentRefundResult{}, err
+		}
+	case payment.Type == "subscription" && refund.CancelSub:
+		var current string
+		if err := tx.QueryRowContext(ctx, `SELECT status FROM subscriptions WHERE id = $1 FOR UPDATE`, payment.EntityID).Scan(&current); err != nil {
+			return PaymentRefundResult{}, err
+		}
+		if finishEntity, err = auditTx(ctx, tx, "subscription", payment.EntityID, string(ActionCancel)); err != nil {
+			return PaymentRefundResult{}, err
+		}
+		reason := refund.Reason.String
+		if reason == "" {
+			reason = "refund " + payment.ID
+		}
+		if result.EntityStatus, err = transitionTx(ctx, tx, payment.EntityID, current, ActionCancel, refund.AdminID.String, reason); err != nil {
+			return PaymentRefundResult{}, err
+		}
+	}
+	if finishEntity != nil {
+		if err := finishEntity(); err != nil {
+			return PaymentRefundResult{}, err
+		}
+	}
+	if err := finishPayment(); err != nil {
+		return PaymentRefundResult{}, err
+	}
+	return result, nil
+}
+
+func sameRefundItems(raw []byte, items []RefundItem) bool {
+	var stored []RefundItem
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return false
+		}
+	}
+	if len(stored) != len(items) {
+		return false
+	}
+	for i := range items {
+		if stored[i] != items[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// orderItemsValueTx checks that items can still be returned and returns
+// their value.
+func orderItemsValueTx(ctx context.Context, tx *sql.Tx, orderID string, items []RefundItem) (int, error) {
+	var value int
+	for _, item := range items {
+		var quantity, refunded, price int
+		err := tx.QueryRowContext(ctx, `
+			SELECT quantity, refunded_quantity, price_cents FROM order_items WHERE id = $1 AND order_id = $2
+		`, item.OrderItemID, orderID).Scan(&quantity, &refunded, &price)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: order item %s not found", ErrRefundInvalid, item.OrderItemID)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if item.Quantity <= 0 || refunded+item.Quantity > quantity {
+			return 0, fmt.Errorf("%w: order item %s has %d left to return", ErrRefundInvalid, item.OrderItemID, quantity-refunded)
+		}
+		value += price * item.Quantity
+	}
+	return value, nil
+}
+
+// returnOrderItemsTx puts returned items back in stock and returns their value.
+func returnOrderItemsTx(ctx context.Context, tx *sql.Tx, orderID string, items []RefundItem) (int, error) {
+	var value int
+	for _, item := range items {
+		var productID string
+		var quantity, refunded, price int
+		err := tx.QueryRowContext(ctx, `
+			SELECT product_id, quantity, refunded_quantity, price_cents FROM order_items WHERE id = $1 AND order_id = $2 FOR UPDATE
+		`, item.OrderItemID, orderID).Scan(&productID, &quantity, &refunded, &price)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: order item %s not found", ErrRefundInvalid, item.OrderItemID)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if item.Quantity <= 0 || refunded+item.Quantity > quantity {
+			return 0, fmt.Errorf("%w: order item %s has %d left to return", ErrRefundInvalid, item.OrderItemID, quantity-refunded)
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE order_items SET refunded_quantity = refunded_quantity + $2 WHERE id = $1`, item.OrderItemID, item.Quantity); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE products SET stock = stock + $2 WHERE id = $1`, productID, item.Quantity); err != nil {
+			return 0, err
+		}
+		value += price * item.Quantity
+	}
+	return value, nil
+}
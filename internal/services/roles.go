@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"nesta/internal/auth"
+)
+
+var (
+	ErrInvalidRole    = errors.New("invalid role")
+	ErrOwnRole        = errors.New("cannot change own role")
+	ErrLastSuperAdmin = errors.New("cannot remove the last super_admin")
+)
+
+type RoleService struct {
+	DB *sql.DB
+}
+
+// SetRole gives the user role and returns the previous one. Losing a staff
+// or courier role revokes the user's sessions so tokens carrying the old role
+// stop working right away. actorID is empty for CLI bootstrap.
+func (s *RoleService) SetRole(ctx context.Context, actorID, userID, role string) (string, error) {
+	if !auth.ValidRole(role) {
+		return "", ErrInvalidRole
+	}
+	if actorID != "" && actorID == userID {
+		return "", ErrOwnRole
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Lock super_admins before the target row so concurrent demotions cannot
+	// both pass the last super_admin check.
+	var superAdmins int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (SELECT id FROM users WHERE role = 'super_admin' ORDER BY id FOR UPDATE) s
+	`).Scan(&superAdmins)
+	if err != nil {
+		return "", err
+	}
+
+	var from string
+	if err = tx.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&from); err != nil {
+		return "", err
+	}
+	if from == role {
+		return from, tx.Commit()
+	}
+	if from == auth.RoleSuperAdmin && superAdmins <= 1 {
+		err = ErrLastSuperAdmin
+		return "", err
+	}
+	finish, err := auditTx(ctx, tx, "user", userID, "role")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE users SET role = $2 WHERE id = $1`, userID, role); err != nil {
+		return "", err
+	}
+	if from == auth.RoleCourier {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM courier_complexes WHERE courier_id = $1`, userID); err != nil {
+			return "", err
+		}
+	}
+	if from != auth.RoleUser {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL
+		`, userID, time.Now())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err = finish(); err != nil {
+		return "", err
+	}
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	return from, nil
+}
+
+func (s *RoleService) CountSuperAdmins(ctx context.Context) (int, error) {
+	var count int
+	err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE role = 'super_admin'`).Scan(&count)
+	return count, err
+}
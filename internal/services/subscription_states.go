@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	SubscriptionPaymentPending = "PAYMENT_PENDING"
+	SubscriptionActive         = "ACTIVE"
+	SubscriptionPaused         = "PAUSED"
+	SubscriptionPastDue        = "PAST_DUE"
+	SubscriptionCanceled       = "CANCELED"
+	SubscriptionExpired        = "EXPIRED"
+)
+
+type SubscriptionAction string
+
+const (
+	ActionActivate SubscriptionAction = "activate"
+	ActionPause    SubscriptionAction = "pause"
+	ActionResume   SubscriptionAction = "resume"
+	ActionCancel   SubscriptionAction = "cancel"
+	ActionOverdue  SubscriptionAction = "overdue"
+	ActionExpire   SubscriptionAction = "expire"
+
+	// These keep the status; they are listed to validate where they are
+	// allowed and are recorded in subscription_events like the others.
+	ActionChangePlan     SubscriptionAction = "change_plan"
+	ActionScheduleCancel SubscriptionAction = "schedule_cancel"
+	ActionUndoCancel     SubscriptionAction = "undo_cancel"
+)
+
+var subscriptionTransitions = map[string]map[SubscriptionAction]string{
+	SubscriptionPaymentPending: {
+		ActionActivate:   SubscriptionActive,
+		ActionCancel:     SubscriptionCanceled,
+		ActionExpire:     SubscriptionExpired,
+		ActionChangePlan: SubscriptionPaymentPending,
+	},
+	SubscriptionActive: {
+		ActionPause:          SubscriptionPaused,
+		ActionCancel:         SubscriptionCanceled,
+		ActionOverdue:        SubscriptionPastDue,
+		ActionChangePlan:     SubscriptionActive,
+		ActionScheduleCancel: SubscriptionActive,
+		ActionUndoCancel:     SubscriptionActive,
+	},
+	SubscriptionPaused: {
+		ActionResume:         SubscriptionActive,
+		ActionCancel:         SubscriptionCanceled,
+		ActionScheduleCancel: SubscriptionPaused,
+		ActionUndoCancel:     SubscriptionPaused,
+	},
+	SubscriptionPastDue: {
+		ActionActivate:       SubscriptionActive,
+		ActionCancel:         SubscriptionCanceled,
+		ActionExpire:         SubscriptionExpired,
+		ActionScheduleCancel: SubscriptionPastDue,
+		ActionUndoCancel:     SubscriptionPastDue,
+	},
+}
+
+type TransitionError struct {
+	From   string
+	Action SubscriptionAction
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot %s subscription in status %s", e.Action, e.From)
+}
+
+func NextSubscriptionStatus(from string, action SubscriptionAction) (string, error) {
+	to, ok := subscriptionTransitions[from][action]
+	if !ok {
+		return "", &TransitionError{From: from, Action: action}
+	}
+	return to, nil
+}
+
+// transitionTx moves a locked subscription row to its next status and records
+// the change in subscription_events. An empty actorID marks a system change.
+func transitionTx(ctx context.Context, tx *sql.Tx, id, from string, action SubscriptionAction, actorID, reason string) (string, error) {
+	to, err := NextSubscriptionStatus(from, action)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE subscriptions SET status = $2 WHERE id = $1`, id, to); err != nil {
+		return "", err
+	}
+
+	eventID, err := NewID()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO subscription_events (id, subscription_id, action, from_status, to_status, actor_id, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, eventID, id, string(action), from, to, nullString(actorID), nullString(reason), time.Now())
+	if err != nil {
+		return "", err
+	}
+	return to, nil
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// transitionMatching applies action to every subscription returned by query,
+// which must select (id, status) and lock the rows.
+func transitionMatching(ctx context.Context, db *sql.DB, action SubscriptionAction, reason, query string, args ...any) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	type match struct {
+		id     string
+		status string
+	}
+	var matches []match
+	for rows.Next() {
+		var item match
+		if err = rows.Scan(&item.id, &item.status); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		matches = append(matches, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, item := range matches {
+		if _, err = transitionTx(ctx, tx, item.id, item.status, action, "", reason); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(matches), nil
+}
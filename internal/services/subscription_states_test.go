@@ -0,0 +1,53 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNextSubscriptionStatus(t *testing.T) {
+	tests := []struct {
+		from   string
+		action SubscriptionAction
+		want   string
+	}{
+		{from: SubscriptionPaymentPending, action: ActionActivate, want: SubscriptionActive},
+		{from: SubscriptionPaymentPending, action: ActionExpire, want: SubscriptionExpired},
+		{from: SubscriptionPaymentPending, action: ActionChangePlan, want: SubscriptionPaymentPending},
+		{from: SubscriptionActive, action: ActionPause, want: SubscriptionPaused},
+		{from: SubscriptionActive, action: ActionOverdue, want: SubscriptionPastDue},
+		{from: SubscriptionActive, action: ActionCancel, want: SubscriptionCanceled},
+		{from: SubscriptionActive, action: ActionChangePlan, want: SubscriptionActive},
+		{from: SubscriptionPaused, action: ActionResume, want: SubscriptionActive},
+		{from: SubscriptionPaused, action: ActionCancel, want: SubscriptionCanceled},
+		{from: SubscriptionPastDue, action: ActionActivate, want: SubscriptionActive},
+		{from: SubscriptionPastDue, action: ActionExpire, want: SubscriptionExpired},
+		{from: SubscriptionActive, action: ActionScheduleCancel, want: SubscriptionActive},
+		{from: SubscriptionPaused, action: ActionUndoCancel, want: SubscriptionPaused},
+		{from: SubscriptionPastDue, action: ActionUndoCancel, want: SubscriptionPastDue},
+		{from: SubscriptionActive, action: ActionActivate},
+		{from: SubscriptionActive, action: ActionResume},
+		{from: SubscriptionPaused, action: ActionPause},
+		{from: SubscriptionPaused, action: ActionChangePlan},
+		{from: SubscriptionPastDue, action: ActionPause},
+		{from: SubscriptionCanceled, action: ActionActivate},
+		{from: SubscriptionCanceled, action: ActionCancel},
+		{from: SubscriptionCanceled, action: ActionUndoCancel},
+		{from: SubscriptionPaymentPending, action: ActionScheduleCancel},
+		{from: SubscriptionExpired, action: ActionActivate},
+		{from: "UNKNOWN", action: ActionCancel},
+	}
+	for _, tt := range tests {
+		got, err := NextSubscriptionStatus(tt.from, tt.action)
+		if tt.want == "" {
+			var transitionErr *TransitionError
+			if !errors.As(err, &transitionErr) || transitionErr.From != tt.from || transitionErr.Action != tt.action {
+				t.Errorf("NextSubscriptionStatus(%s, %s) = %q, %v; want TransitionError", tt.from, tt.action, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NextSubscriptionStatus(%s, %s) = %q, %v; want %s", tt.from, tt.action, got, err, tt.want)
+		}
+	}
+}
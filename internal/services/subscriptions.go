@@ -6,13 +6,23 @@ import (
 	"errors"
 	"time"
 
+	"nesta/internal/frequency"
 	"nesta/internal/repositories"
 )
 
+const (
+	CancelPolicyImmediate   = "immediate"
+	CancelPolicyAtPeriodEnd = "at_period_end"
+)
+
 type SubscriptionService struct {
-	Subscriptions *repositories.SubscriptionRepository
-	Complexes     *repositories.ComplexRepository
-	Plans         *repositories.PlanRepository
+	DB              *sql.DB
+	Subscriptions   *repositories.SubscriptionRepository
+	Complexes       *repositories.ComplexRepository
+	Plans           *repositories.PlanRepository
+	Payments        *PaymentService
+	PaymentProvider string
+	CancelPolicy    string
 }
 
 type SubscriptionCreateResult struct {
@@ -36,16 +46,20 @@ func (s *SubscriptionService) Create(ctx context.Context, userID, complexID, pla
 	if !plan.IsActive {
 		return SubscriptionCreateResult{}, errors.New("plan not active")
 	}
+	freq, err := frequency.Parse(plan.Frequency)
+	if err != nil {
+		return SubscriptionCreateResult{}, err
+	}
 
 	id, err := NewID()
 	if err != nil {
 		return SubscriptionCreateResult{}, err
 	}
 
-	status := "ACTIVE"
+	status := SubscriptionActive
 	requiresPayment := plan.PriceCents > 0
 	if requiresPayment {
-		status = "PAYMENT_PENDING"
+		status = SubscriptionPaymentPending
 	}
 
 	subscription := repositories.Subscription{
@@ -63,16 +77,187 @@ func (s *SubscriptionService) Create(ctx context.Context, userID, complexID, pla
 	if instructions != "" {
 		subscription.Instructions = sql.NullString{String: instructions, Valid: true}
 	}
+	if !requiresPayment {
+		periodStart := time.Now()
+		subscription.CurrentPeriodStart = sql.NullTime{Time: periodStart, Valid: true}
+		subscription.CurrentPeriodEnd = sql.NullTime{Time: freq.Next(periodStart), Valid: true}
+	}
 
 	if err := s.Subscriptions.Create(ctx, subscription); err != nil {
 		return SubscriptionCreateResult{}, err
 	}
 
-	subscription.CurrentPeriodStart = sql.NullTime{Time: time.Now(), Valid: true}
-	subscription.CurrentPeriodEnd = sql.NullTime{Time: time.Now().Add(30 * 24 * time.Hour), Valid: true}
 	return SubscriptionCreateResult{Subscription: subscription, RequiresPayment: requiresPayment}, nil
 }
 
-func (s *SubscriptionService) UpdateStatus(ctx context.Context, id, status string) error {
-	return s.Subscriptions.UpdateStatus(ctx, id, status)
+func (s *SubscriptionService) Transition(ctx context.Context, id string, action SubscriptionAction, actorID, reason string) (repositories.Subscription, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var current string
+	if err = tx.QueryRowContext(ctx, `SELECT status FROM subscriptions WHERE id = $1 FOR UPDATE`, id).Scan(&current); err != nil {
+		return repositories.Subscription{}, err
+	}
+	finish, err := auditTx(ctx, tx, "subscription", id, string(action))
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if _, err = transitionTx(ctx, tx, id, current, action, actorID, reason); err != nil {
+		return repositories.Subscription{}, err
+	}
+	if err = finish(); err != nil {
+		return repositories.Subscription{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return repositories.Subscription{}, err
+	}
+
+	return s.Subscriptions.Get(ctx, id)
+}
+
+// Cancel follows CancelPolicy unless immediate is forced (admin actions).
+// With at_period_end the subscription keeps its status and only gets
+// cancel_at.
+func (s *SubscriptionService) Cancel(ctx context.Context, id string, immediate bool, actorID string) (repositories.Subscription, error) {
+	sub, err := s.Subscriptions.Get(ctx, id)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if _, err := NextSubscriptionStatus(sub.Status, ActionCancel); err != nil {
+		return repositories.Subscription{}, err
+	}
+
+	now := time.Now()
+	atPeriodEnd := !immediate && s.CancelPolicy == CancelPolicyAtPeriodEnd &&
+		sub.CurrentPeriodEnd.Valid && sub.CurrentPeriodEnd.Time.After(now)
+	if !atPeriodEnd {
+		sub, err = s.Transition(ctx, id, ActionCancel, actorID, "")
+		if err != nil {
+			return repositories.Subscription{}, err
+		}
+		sub.CancelAt = sql.NullTime{Time: now, Valid: true}
+		return sub, nil
+	}
+
+	return s.scheduleCancel(ctx, id, actorID)
+}
+
+// scheduleCancel sets cancel_at to the end of the paid period. An open
+// renewal not yet sent to the provider is canceled; one already sent may
+// still be paid, and then paying it moves cancel_at to the end of the period
+// it pays for (see activateSubscription).
+func (s *SubscriptionService) scheduleCancel(ctx context.Context, id, actorID string) (repositories.Subscription, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var current string
+	var periodEnd sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT status, current_period_end FROM subscriptions WHERE id = $1 FOR UPDATE
+	`, id).Scan(&current, &periodEnd)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if !periodEnd.Valid {
+		err = &TransitionError{From: current, Action: ActionScheduleCancel}
+		return repositories.Subscription{}, err
+	}
+	finish, err := auditTx(ctx, tx, "subscription", id, string(ActionScheduleCancel))
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if _, err = transitionTx(ctx, tx, id, current, ActionScheduleCancel, actorID, ""); err != nil {
+		return repositories.Subscription{}, err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE subscriptions SET cancel_at = $2 WHERE id = $1`, id, periodEnd.Time); err != nil {
+		return repositories.Subscription{}, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE payments SET status = $2
+		WHERE type = 'subscription' AND entity_id = $1 AND period_start IS NOT NULL AND status = $3 AND checkout_at IS NULL
+	`, id, PaymentCanceled, PaymentInit)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if err = finish(); err != nil {
+		return repositories.Subscription{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return repositories.Subscription{}, err
+	}
+	return s.Subscriptions.Get(ctx, id)
+}
+
+// UndoCancel drops a scheduled cancellation and reopens the renewal that
+// scheduling it canceled, since billing creates one renewal per period.
+func (s *SubscriptionService) UndoCancel(ctx context.Context, id, actorID string) (repositories.Subscription, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var current string
+	var cancelAt, periodEnd sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT status, cancel_at, current_period_end FROM subscriptions WHERE id = $1 FOR UPDATE
+	`, id).Scan(&current, &cancelAt, &periodEnd)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if !cancelAt.Valid || current == SubscriptionCanceled || current == SubscriptionExpired {
+		err = errors.New("no pending cancellation")
+		return repositories.Subscription{}, err
+	}
+	finish, err := auditTx(ctx, tx, "subscription", id, string(ActionUndoCancel))
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if _, err = transitionTx(ctx, tx, id, current, ActionUndoCancel, actorID, ""); err != nil {
+		return repositories.Subscription{}, err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE subscriptions SET cancel_at = NULL WHERE id = $1`, id); err != nil {
+		return repositories.Subscription{}, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE payments SET status = $3
+		WHERE type = 'subscription' AND entity_id = $1 AND period_start = $2 AND status = $4 AND checkout_at IS NULL
+	`, id, periodEnd, PaymentInit, PaymentCanceled)
+	if err != nil {
+		return repositories.Subscription{}, err
+	}
+	if err = finish(); err != nil {
+		return repositories.Subscription{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return repositories.Subscription{}, err
+	}
+	return s.Subscriptions.Get(ctx, id)
+}
+
+func (s *SubscriptionService) FinalizeCancellations(ctx context.Context) error {
+	_, err := transitionMatching(ctx, s.DB, ActionCancel, "scheduled cancellation", `
+		SELECT id, status FROM subscriptions
+		WHERE cancel_at IS NOT NULL AND cancel_at <= $1 AND status IN ('ACTIVE', 'PAUSED', 'PAST_DUE')
+		FOR UPDATE SKIP LOCKED
+	`, time.Now())
+	return err
 }
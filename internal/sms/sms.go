@@ -0,0 +1,122 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const MessageTemplate = "Nesta: код подтверждения %s"
+
+func message(code string) string {
+	return fmt.Sprintf(MessageTemplate, code)
+}
+
+// HTTPSender posts {"to", "text"} as JSON to a generic SMS gateway.
+type HTTPSender struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func NewHTTPSender(url, token string) *HTTPSender {
+	return &HTTPSender{URL: url, Token: token, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *HTTPSender) SendOTP(ctx context.Context, phone, code string) error {
+	body, err := json.Marshal(map[string]string{"to": phone, "text": message(code)})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sms gateway returned %d: %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// WriterSender prints codes instead of sending them. For development only.
+type WriterSender struct {
+	mu sync.Mutex
+	W  io.Writer
+}
+
+func NewConsoleSender() *WriterSender {
+	return &WriterSender{W: os.Stdout}
+}
+
+func (s *WriterSender) SendOTP(ctx context.Context, phone, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.W, "%s otp to=%s text=%q\n", time.Now().Format(time.RFC3339), phone, message(code))
+	return err
+}
+
+// FileSender appends codes to a local file. For development only.
+type FileSender struct {
+	mu   sync.Mutex
+	Path string
+}
+
+func (s *FileSender) SendOTP(ctx context.Context, phone, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "%s otp to=%s text=%q\n", time.Now().Format(time.RFC3339), phone, message(code))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// FakeSender keeps the last code per phone in memory so tests can read it back.
+// Setting Err makes every send fail.
+type FakeSender struct {
+	mu    sync.Mutex
+	codes map[string]string
+	Err   error
+}
+
+func (s *FakeSender) SendOTP(ctx context.Context, phone, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	if s.codes == nil {
+		s.codes = map[string]string{}
+	}
+	s.codes[phone] = code
+	return nil
+}
+
+func (s *FakeSender) LastCode(phone string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[phone]
+	return code, ok
+}
@@ -0,0 +1,66 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFakeSender(t *testing.T) {
+	ctx := context.Background()
+	s := &FakeSender{}
+	if _, ok := s.LastCode("+77011234567"); ok {
+		t.Fatal("LastCode before any send")
+	}
+	s.SendOTP(ctx, "+77011234567", "1111")
+	s.SendOTP(ctx, "+77011234567", "2222")
+	if code, ok := s.LastCode("+77011234567"); !ok || code != "2222" {
+		t.Errorf("LastCode = %q, %v; want 2222", code, ok)
+	}
+
+	s.Err = errors.New("down")
+	if err := s.SendOTP(ctx, "+77017654321", "3333"); !errors.Is(err, s.Err) {
+		t.Errorf("SendOTP = %v, want %v", err, s.Err)
+	}
+	if _, ok := s.LastCode("+77017654321"); ok {
+		t.Error("failed send must not store the code")
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	ctx := context.Background()
+	s := &FileSender{Path: filepath.Join(t.TempDir(), "sms.log")}
+	for _, code := range []string{"1111", "2222"} {
+		if err := s.SendOTP(ctx, "+77011234567", code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), data)
+	}
+	for i, code := range []string{"1111", "2222"} {
+		if !strings.Contains(lines[i], "to=+77011234567") || !strings.Contains(lines[i], message(code)) {
+			t.Errorf("line %d = %q", i, lines[i])
+		}
+	}
+}
+
+func TestWriterSender(t *testing.T) {
+	var buf bytes.Buffer
+	s := &WriterSender{W: &buf}
+	if err := s.SendOTP(context.Background(), "+77011234567", "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "to=+77011234567") || !strings.Contains(out, message("1234")) {
+		t.Errorf("output = %q", out)
+	}
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+type Runner struct {
+	Logger zerolog.Logger
+	Jobs   []Job
+	wg     sync.WaitGroup
+}
+
+func (r *Runner) Start(ctx context.Context) {
+	for _, job := range r.Jobs {
+		r.wg.Add(1)
+		go r.loop(ctx, job)
+	}
+}
+
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+func (r *Runner) loop(ctx context.Context, job Job) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.runOnce(ctx, job)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) runOnce(ctx context.Context, job Job) {
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		r.Logger.Error().Err(err).Str("job", job.Name).Msg("job failed")
+		return
+	}
+	r.Logger.Debug().Str("job", job.Name).Dur("latency", time.Since(start)).Msg("job completed")
+}
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS period_start TIMESTAMPTZ;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS period_end TIMESTAMPTZ;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_payments_subscription_period ON payments(entity_id, period_start)
+    WHERE type = 'subscription' AND period_start IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_status_period_end ON subscriptions(status, current_period_end);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_subscriptions_status_period_end;
+DROP INDEX IF EXISTS uniq_payments_subscription_period;
+ALTER TABLE payments DROP COLUMN IF EXISTS period_end;
+ALTER TABLE payments DROP COLUMN IF EXISTS period_start;
@@ -0,0 +1,25 @@
+-- +goose Up
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS frequency_legacy TEXT;
+UPDATE plans SET frequency_legacy = frequency WHERE frequency_legacy IS NULL;
+
+-- The billing period is derived from the legacy value. Anything that cannot
+-- be mapped reliably becomes monthly; the original value stays in
+-- frequency_legacy for review.
+UPDATE plans SET frequency = CASE
+        WHEN LOWER(TRIM(frequency_legacy)) IN ('daily', 'day', 'ежедневно') THEN 'days:1'
+        WHEN LOWER(TRIM(frequency_legacy)) IN ('weekly', 'week', 'еженедельно') THEN 'weekly'
+        WHEN LOWER(TRIM(frequency_legacy)) IN ('monthly', 'month', 'ежемесячно') THEN 'monthly'
+        WHEN LOWER(TRIM(frequency_legacy)) IN ('quarterly', 'quarter', 'ежеквартально') THEN 'quarterly'
+        WHEN LOWER(TRIM(frequency_legacy)) IN ('yearly', 'year', 'annual', 'annually', 'ежегодно') THEN 'yearly'
+        WHEN LOWER(TRIM(frequency_legacy)) ~ '^days:[1-9][0-9]*$' THEN LOWER(TRIM(frequency_legacy))
+        ELSE 'monthly'
+    END
+WHERE frequency_legacy IS NOT NULL;
+
+ALTER TABLE plans ADD CONSTRAINT plans_frequency_check
+    CHECK (frequency ~ '^(weekly|monthly|quarterly|yearly|days:[1-9][0-9]*)$');
+
+-- +goose Down
+ALTER TABLE plans DROP CONSTRAINT IF EXISTS plans_frequency_check;
+UPDATE plans SET frequency = frequency_legacy WHERE frequency_legacy IS NOT NULL;
+ALTER TABLE plans DROP COLUMN IF EXISTS frequency_legacy;
@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS cancel_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_cancel_at ON subscriptions(cancel_at) WHERE cancel_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_subscriptions_cancel_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS cancel_at;
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS subscription_pauses (
+    id TEXT PRIMARY KEY,
+    subscription_id TEXT NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    starts_on DATE NOT NULL,
+    ends_on DATE,
+    status TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_pauses_subscription ON subscription_pauses(subscription_id, status);
+CREATE INDEX IF NOT EXISTS idx_subscription_pauses_status_dates ON subscription_pauses(status, starts_on, ends_on);
+
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS max_pause_days INT;
+
+-- +goose Down
+ALTER TABLE plans DROP COLUMN IF EXISTS max_pause_days;
+DROP TABLE IF EXISTS subscription_pauses;
@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS subscription_plan_changes (
+    id TEXT PRIMARY KEY,
+    subscription_id TEXT NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    from_plan_id TEXT NOT NULL REFERENCES plans(id),
+    to_plan_id TEXT NOT NULL REFERENCES plans(id),
+    kind TEXT NOT NULL,
+    amount_cents INT NOT NULL DEFAULT 0,
+    status TEXT NOT NULL,
+    effective_at TIMESTAMPTZ NOT NULL,
+    applied_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_plan_changes_subscription ON subscription_plan_changes(subscription_id, status);
+CREATE INDEX IF NOT EXISTS idx_plan_changes_status_effective ON subscription_plan_changes(status, effective_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS subscription_plan_changes;
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS pickup_frequency TEXT NOT NULL DEFAULT 'days:1';
+ALTER TABLE plans ADD CONSTRAINT plans_pickup_frequency_check
+    CHECK (pickup_frequency ~ '^(weekly|monthly|quarterly|yearly|days:[1-9][0-9]*)$');
+
+-- Bitmask of service weekdays, bit 0 = Sunday ... bit 6 = Saturday. 127 = every day.
+ALTER TABLE residential_complexes ADD COLUMN IF NOT EXISTS service_days INT NOT NULL DEFAULT 127;
+
+ALTER TABLE pickup_logs ADD COLUMN IF NOT EXISTS time_window TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_pickup_logs_status_date ON pickup_logs(status, pickup_date);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_pickup_logs_status_date;
+ALTER TABLE pickup_logs DROP COLUMN IF EXISTS time_window;
+ALTER TABLE residential_complexes DROP COLUMN IF EXISTS service_days;
+ALTER TABLE plans DROP CONSTRAINT IF EXISTS plans_pickup_frequency_check;
+ALTER TABLE plans DROP COLUMN IF EXISTS pickup_frequency;
@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS courier_complexes (
+    courier_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    complex_id TEXT NOT NULL REFERENCES residential_complexes(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (courier_id, complex_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_courier_complexes_complex ON courier_complexes(complex_id);
+
+ALTER TABLE pickup_logs ADD COLUMN IF NOT EXISTS photo_ref TEXT;
+ALTER TABLE pickup_logs ADD COLUMN IF NOT EXISTS courier_id TEXT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE pickup_logs ADD COLUMN IF NOT EXISTS completed_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE pickup_logs DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE pickup_logs DROP COLUMN IF EXISTS courier_id;
+ALTER TABLE pickup_logs DROP COLUMN IF EXISTS photo_ref;
+DROP TABLE IF EXISTS courier_complexes;
@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS pickup_log_history (
+    id TEXT PRIMARY KEY,
+    pickup_log_id TEXT NOT NULL REFERENCES pickup_logs(id) ON DELETE CASCADE,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    reason TEXT,
+    comment TEXT,
+    actor_id TEXT,
+    source TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_pickup_log_history_log ON pickup_log_history(pickup_log_id, created_at);
+
+-- Keep the newest log per (subscription_id, pickup_date); older duplicates become its history.
+WITH ranked AS (
+    SELECT id, status, reason, comment, created_at,
+        FIRST_VALUE(id) OVER w AS keep_id,
+        ROW_NUMBER() OVER w AS rn
+    FROM pickup_logs
+    WINDOW w AS (PARTITION BY subscription_id, pickup_date ORDER BY created_at DESC, id DESC)
+)
+INSERT INTO pickup_log_history (id, pickup_log_id, to_status, reason, comment, source, created_at)
+SELECT md5(id || keep_id), keep_id, status, reason, comment, 'migration', created_at
+FROM ranked
+WHERE rn > 1;
+
+DELETE FROM pickup_logs p
+USING pickup_logs newer
+WHERE p.subscription_id = newer.subscription_id
+  AND p.pickup_date = newer.pickup_date
+  AND (newer.created_at, newer.id) > (p.created_at, p.id);
+
+DROP INDEX IF EXISTS idx_pickup_logs_subscription_date;
+ALTER TABLE pickup_logs ADD CONSTRAINT pickup_logs_subscription_date_key UNIQUE (subscription_id, pickup_date);
+
+-- +goose Down
+ALTER TABLE pickup_logs DROP CONSTRAINT IF EXISTS pickup_logs_subscription_date_key;
+CREATE INDEX IF NOT EXISTS idx_pickup_logs_subscription_date ON pickup_logs(subscription_id, pickup_date);
+DROP TABLE IF EXISTS pickup_log_history;
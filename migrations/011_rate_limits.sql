@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS rate_limit_hits (
+    key TEXT NOT NULL,
+    hit_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_hits_key_time ON rate_limit_hits(key, hit_at);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_hits_time ON rate_limit_hits(hit_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS rate_limit_hits;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE otp_codes ADD COLUMN IF NOT EXISTS used_at TIMESTAMPTZ;
+
+-- Codes hashed with the old unsalted scheme can no longer be verified.
+UPDATE otp_codes SET used_at = NOW() WHERE used_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_otp_phone_created ON otp_codes(phone, created_at DESC);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_otp_phone_created;
+ALTER TABLE otp_codes DROP COLUMN IF EXISTS used_at;
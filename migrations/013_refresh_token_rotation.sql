@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS replaced_by TEXT REFERENCES refresh_tokens(id) ON DELETE SET NULL;
+
+-- Tokens issued so far keep working: their stored value becomes its SHA-256
+-- and each one starts its own family.
+UPDATE refresh_tokens SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex'), family_id = id;
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id) WHERE revoked_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_refresh_tokens_family;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS replaced_by;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS family_id;
+-- Hashes cannot be turned back into tokens; every session has to log in again.
+UPDATE refresh_tokens SET revoked_at = NOW() WHERE revoked_at IS NULL;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
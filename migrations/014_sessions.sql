@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS device_name TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+
+UPDATE refresh_tokens SET last_used_at = created_at WHERE last_used_at IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET DEFAULT NOW();
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_active ON refresh_tokens(user_id) WHERE revoked_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_refresh_tokens_user_active;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS ip;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS device_name;
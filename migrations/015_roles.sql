@@ -0,0 +1,21 @@
+-- +goose Up
+-- The admins table was never read; users.role is the only source of roles.
+UPDATE users SET role = 'admin' WHERE role = 'user' AND id IN (SELECT user_id FROM admins);
+DROP TABLE IF EXISTS admins;
+
+ALTER TABLE users ADD CONSTRAINT users_role_check
+    CHECK (role IN ('user', 'courier', 'support', 'admin', 'super_admin'));
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role) WHERE role <> 'user';
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_role;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+UPDATE users SET role = 'admin' WHERE role = 'super_admin';
+UPDATE users SET role = 'user' WHERE role = 'support';
+
+CREATE TABLE IF NOT EXISTS admins (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+INSERT INTO admins (id, user_id) SELECT md5(id), id FROM users WHERE role = 'admin';
@@ -0,0 +1,25 @@
+-- +goose Up
+-- Every webhook as received. event_id is only set once the signature checked
+-- out, so forged requests cannot claim an id ahead of the real event.
+CREATE TABLE IF NOT EXISTS payment_events (
+    id TEXT PRIMARY KEY,
+    provider TEXT NOT NULL,
+    event_id TEXT,
+    payment_id TEXT REFERENCES payments(id) ON DELETE SET NULL,
+    headers_json JSONB NOT NULL,
+    body BYTEA NOT NULL,
+    signature_valid BOOLEAN NOT NULL,
+    status TEXT,
+    outcome TEXT NOT NULL,
+    error TEXT,
+    duplicates INT NOT NULL DEFAULT 0,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    processed_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_payment_events_provider_event ON payment_events(provider, event_id);
+CREATE INDEX IF NOT EXISTS idx_payment_events_payment ON payment_events(payment_id, received_at);
+CREATE INDEX IF NOT EXISTS idx_payment_events_received ON payment_events(received_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS payment_events;
@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS payment_refunds (
+    id TEXT PRIMARY KEY,
+    payment_id TEXT NOT NULL REFERENCES payments(id) ON DELETE RESTRICT,
+    amount_cents INT NOT NULL CHECK (amount_cents > 0),
+    reason TEXT,
+    items_json JSONB,
+    cancel_subscription BOOLEAN NOT NULL DEFAULT false,
+    provider_refund_id TEXT,
+    status TEXT NOT NULL,
+    admin_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_refunds_payment ON payment_refunds(payment_id, created_at);
+-- A refund is PENDING from before the provider call until it is booked; its
+-- id is the idempotency key, so a payment has one refund in flight at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_payment_refunds_pending ON payment_refunds(payment_id) WHERE status = 'PENDING';
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_cents INT NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS refunded_quantity INT NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE order_items DROP COLUMN IF EXISTS refunded_quantity;
+ALTER TABLE payments DROP COLUMN IF EXISTS refunded_cents;
+DROP TABLE IF EXISTS payment_refunds;
@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS idx_payments_open_created ON payments(created_at) WHERE status IN ('INIT', 'PENDING');
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_payments_open_created;
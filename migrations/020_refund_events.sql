@@ -0,0 +1,9 @@
+-- +goose Up
+-- Refunds made at the provider and learned from its webhook point at the
+-- event that booked them; admin refunds have no event.
+ALTER TABLE payment_refunds ADD COLUMN IF NOT EXISTS event_id TEXT REFERENCES payment_events(id) ON DELETE SET NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_payment_refunds_event ON payment_refunds(event_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS uniq_payment_refunds_event;
+ALTER TABLE payment_refunds DROP COLUMN IF EXISTS event_id;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- A payment that arrived after its order or subscription could no longer take
+-- it (canceled, already paid) is kept as PAID with the reason it has to be
+-- refunded; the admin refund clears it.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refund_required TEXT;
+CREATE INDEX IF NOT EXISTS idx_payments_refund_required ON payments(created_at) WHERE refund_required IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    entity TEXT NOT NULL,
+    entity_id TEXT NOT NULL,
+    payload_json JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_created ON notifications(user_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS notifications;
+DROP INDEX IF EXISTS idx_payments_refund_required;
+ALTER TABLE payments DROP COLUMN IF EXISTS refund_required;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- When the payment was first handed to the provider, as a checkout or a
+-- saved-card charge. Billing creates renewals that may never get there.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS checkout_at TIMESTAMPTZ;
+UPDATE payments SET checkout_at = created_at WHERE period_start IS NULL OR status NOT IN ('INIT', 'EXPIRED');
+
+-- +goose Down
+ALTER TABLE payments DROP COLUMN IF EXISTS checkout_at;